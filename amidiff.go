@@ -0,0 +1,144 @@
+package ead
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+var (
+	releaseVersionRegex    = regexp.MustCompile(`(\d+\.\d+\.\d{8})`)
+	ecsAgentVersionRegex   = regexp.MustCompile(`(?i)ecs[- _]?agent[- _:]*v?(\d+(?:\.\d+)+)`)
+	dockerVersionRegex     = regexp.MustCompile(`(?i)docker[- _:]*(?:ce[- _:]*)?v?(\d+(?:\.\d+)+)`)
+	containerdVersionRegex = regexp.MustCompile(`(?i)containerd[- _:]*v?(\d+(?:\.\d+)+)`)
+	kernelVersionRegex     = regexp.MustCompile(`(?i)kernel[- _:]*(\d+(?:\.\d+)+)`)
+)
+
+// AMIDiff describes the differences between the image currently used by a cluster and a candidate image
+type AMIDiff struct {
+	CurrentImageID string
+	TargetImageID  string
+	Fields         []AMIDiffField
+}
+
+// AMIDiffField holds the current and target values of a single image attribute
+type AMIDiffField struct {
+	Name    string
+	Current string
+	Target  string
+	Changed bool
+}
+
+// HasChanges returns true if any of the compared attributes differ
+func (d AMIDiff) HasChanges() bool {
+	for _, f := range d.Fields {
+		if f.Changed {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffAMI compares the image currently used by the cluster's launch template with the latest
+// AMI for the configured filter
+func (u *Upgrader) DiffAMI() (AMIDiff, error) {
+	if u.cluster == "" {
+		return AMIDiff{}, fmt.Errorf("cluster name must be set in config to compare AMIs")
+	}
+
+	target, err := u.findUpgradeTarget()
+	if err != nil {
+		return AMIDiff{}, err
+	}
+
+	return DiffAMIs(target.currentImage, target.latestImage), nil
+}
+
+// DiffAMIs compares two images, including the component versions that can be parsed from
+// their names and descriptions
+func DiffAMIs(current, target ec2types.Image) AMIDiff {
+	diff := AMIDiff{
+		CurrentImageID: aws.ToString(current.ImageId),
+		TargetImageID:  aws.ToString(target.ImageId),
+	}
+
+	add := func(name, currentValue, targetValue string) {
+		diff.Fields = append(diff.Fields, AMIDiffField{
+			Name:    name,
+			Current: currentValue,
+			Target:  targetValue,
+			Changed: currentValue != targetValue,
+		})
+	}
+
+	add("Image ID", aws.ToString(current.ImageId), aws.ToString(target.ImageId))
+	add("Name", aws.ToString(current.Name), aws.ToString(target.Name))
+	add("Creation date", aws.ToString(current.CreationDate), aws.ToString(target.CreationDate))
+	add("Release", parseImageVersion(current, releaseVersionRegex), parseImageVersion(target, releaseVersionRegex))
+	add("ECS agent", parseImageVersion(current, ecsAgentVersionRegex), parseImageVersion(target, ecsAgentVersionRegex))
+	add("Docker", parseImageVersion(current, dockerVersionRegex), parseImageVersion(target, dockerVersionRegex))
+	add("containerd", parseImageVersion(current, containerdVersionRegex), parseImageVersion(target, containerdVersionRegex))
+	add("Kernel", imageKernel(current), imageKernel(target))
+	add("Architecture", string(current.Architecture), string(target.Architecture))
+	add("Boot mode", string(current.BootMode), string(target.BootMode))
+	add("Root device", aws.ToString(current.RootDeviceName), aws.ToString(target.RootDeviceName))
+	add("Deprecation time", aws.ToString(current.DeprecationTime), aws.ToString(target.DeprecationTime))
+
+	currentDevices := describeBlockDevices(current.BlockDeviceMappings)
+	targetDevices := describeBlockDevices(target.BlockDeviceMappings)
+	var deviceNames []string
+	for name := range currentDevices {
+		deviceNames = append(deviceNames, name)
+	}
+	for name := range targetDevices {
+		if _, ok := currentDevices[name]; !ok {
+			deviceNames = append(deviceNames, name)
+		}
+	}
+	sort.Strings(deviceNames)
+	for _, name := range deviceNames {
+		add("Block device "+name, currentDevices[name], targetDevices[name])
+	}
+
+	return diff
+}
+
+// parseImageVersion returns the first version matched by re in the image name, or failing that, in the
+// image description. An empty string is returned if neither contains a match.
+func parseImageVersion(image ec2types.Image, re *regexp.Regexp) string {
+	for _, s := range []*string{image.Name, image.Description} {
+		if m := re.FindStringSubmatch(aws.ToString(s)); len(m) > 1 {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// imageKernel returns the kernel version from the image name or description, falling back to the kernel ID
+// for images that specify one
+func imageKernel(image ec2types.Image) string {
+	if v := parseImageVersion(image, kernelVersionRegex); v != "" {
+		return v
+	}
+	return aws.ToString(image.KernelId)
+}
+
+// describeBlockDevices summarizes the image's block device mappings, keyed by device name
+func describeBlockDevices(mappings []ec2types.BlockDeviceMapping) map[string]string {
+	devices := make(map[string]string, len(mappings))
+	for _, m := range mappings {
+		name := aws.ToString(m.DeviceName)
+		switch {
+		case m.Ebs != nil:
+			devices[name] = fmt.Sprintf("%s %dGiB", m.Ebs.VolumeType, aws.ToInt32(m.Ebs.VolumeSize))
+		case m.VirtualName != nil:
+			devices[name] = *m.VirtualName
+		default:
+			devices[name] = "no device"
+		}
+	}
+	return devices
+}
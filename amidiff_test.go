@@ -0,0 +1,98 @@
+package ead
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func Test_parseImageVersion(t *testing.T) {
+	al2023 := ec2types.Image{
+		Name:        aws.String("al2023-ami-ecs-hvm-2023.0.20231114-kernel-6.1-x86_64"),
+		Description: aws.String("Amazon Linux AMI 2023.0.20231114 x86_64 ECS HVM EBS"),
+	}
+	windows := ec2types.Image{
+		Name:        aws.String("Windows_Server-2019-English-Full-ECS_Optimized-2023.11.15"),
+		Description: aws.String("Microsoft Windows Server 2019 with ECS Agent v1.78.1 and Docker 20.10.23 and containerd 1.6.24"),
+	}
+
+	tests := []struct {
+		name  string
+		image ec2types.Image
+		field string
+		want  string
+	}{
+		{name: "release from name", image: al2023, field: "release", want: "2023.0.20231114"},
+		{name: "kernel from name", image: al2023, field: "kernel", want: "6.1"},
+		{name: "no agent version", image: al2023, field: "agent", want: ""},
+		{name: "agent from description", image: windows, field: "agent", want: "1.78.1"},
+		{name: "docker from description", image: windows, field: "docker", want: "20.10.23"},
+		{name: "containerd from description", image: windows, field: "containerd", want: "1.6.24"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			switch tt.field {
+			case "release":
+				got = parseImageVersion(tt.image, releaseVersionRegex)
+			case "kernel":
+				got = imageKernel(tt.image)
+			case "agent":
+				got = parseImageVersion(tt.image, ecsAgentVersionRegex)
+			case "docker":
+				got = parseImageVersion(tt.image, dockerVersionRegex)
+			case "containerd":
+				got = parseImageVersion(tt.image, containerdVersionRegex)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffAMIs(t *testing.T) {
+	current := ec2types.Image{
+		ImageId:      aws.String("ami-1"),
+		Name:         aws.String("al2023-ami-ecs-hvm-2023.0.20231016-kernel-6.1-x86_64"),
+		CreationDate: aws.String("2023-10-16T00:00:00Z"),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2types.EbsBlockDevice{VolumeSize: aws.Int32(30), VolumeType: "gp3"}},
+		},
+	}
+	target := ec2types.Image{
+		ImageId:      aws.String("ami-2"),
+		Name:         aws.String("al2023-ami-ecs-hvm-2023.0.20231114-kernel-6.1-x86_64"),
+		CreationDate: aws.String("2023-11-14T00:00:00Z"),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2types.EbsBlockDevice{VolumeSize: aws.Int32(30), VolumeType: "gp3"}},
+			{DeviceName: aws.String("/dev/xvdb"), VirtualName: aws.String("ephemeral0")},
+		},
+	}
+
+	diff := DiffAMIs(current, target)
+	if !diff.HasChanges() {
+		t.Fatal("expected changes")
+	}
+
+	changed := map[string]bool{}
+	for _, f := range diff.Fields {
+		changed[f.Name] = f.Changed
+	}
+	for name, want := range map[string]bool{
+		"Release":                true,
+		"Kernel":                 false,
+		"Block device /dev/xvda": false,
+		"Block device /dev/xvdb": true,
+	} {
+		got, ok := changed[name]
+		if !ok {
+			t.Errorf("field %q missing from diff", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("field %q changed = %t, want %t", name, got, want)
+		}
+	}
+}
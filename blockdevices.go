@@ -0,0 +1,79 @@
+package ead
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// reconcileBlockDeviceMappings updates a launch template's block device mappings for a new image. The
+// root volume follows the image's root device name, volumes that come from the image are grown to at
+// least the size of the image's snapshot, and snapshot IDs from the old image are removed. Other volumes,
+// such as custom data volumes, are kept as they are. It returns a description of each change, or an error
+// if the mappings can't be made to work with the new image.
+func reconcileBlockDeviceMappings(mappings []ec2types.LaunchTemplateBlockDeviceMappingRequest, oldImage,
+	newImage ec2types.Image) ([]ec2types.LaunchTemplateBlockDeviceMappingRequest, []string, error) {
+
+	oldRoot := aws.ToString(oldImage.RootDeviceName)
+	newRoot := aws.ToString(newImage.RootDeviceName)
+
+	oldSnapshots := map[string]bool{}
+	for _, b := range oldImage.BlockDeviceMappings {
+		if b.Ebs != nil && b.Ebs.SnapshotId != nil {
+			oldSnapshots[*b.Ebs.SnapshotId] = true
+		}
+	}
+	newDevices := map[string]ec2types.BlockDeviceMapping{}
+	for _, b := range newImage.BlockDeviceMappings {
+		newDevices[aws.ToString(b.DeviceName)] = b
+	}
+
+	var changes []string
+	seen := map[string]bool{}
+	out := make([]ec2types.LaunchTemplateBlockDeviceMappingRequest, len(mappings))
+	for i, m := range mappings {
+		device := aws.ToString(m.DeviceName)
+		if device == oldRoot && newRoot != "" && oldRoot != newRoot {
+			changes = append(changes, fmt.Sprintf("root device renamed from %s to %s", oldRoot, newRoot))
+			device = newRoot
+			m.DeviceName = aws.String(newRoot)
+		} else if device == newRoot && oldRoot != newRoot {
+			return nil, nil, fmt.Errorf("block device %s is used for a data volume but is the root device of image %s",
+				device, aws.ToString(newImage.ImageId))
+		}
+		if seen[device] {
+			return nil, nil, fmt.Errorf("more than one block device mapping for device %s", device)
+		}
+		seen[device] = true
+
+		if m.Ebs == nil {
+			out[i] = m
+			continue
+		}
+		ebs := *m.Ebs
+		m.Ebs = &ebs
+
+		imageDevice, inNewImage := newDevices[device]
+		if ebs.SnapshotId != nil && (inNewImage || oldSnapshots[*ebs.SnapshotId]) {
+			ebs.SnapshotId = nil
+		}
+
+		switch {
+		case inNewImage && imageDevice.Ebs != nil:
+			minSize := aws.ToInt32(imageDevice.Ebs.VolumeSize)
+			if ebs.VolumeSize != nil && *ebs.VolumeSize < minSize {
+				changes = append(changes, fmt.Sprintf("volume size of %s increased from %d to %d GiB, the minimum for image %s",
+					device, *ebs.VolumeSize, minSize, aws.ToString(newImage.ImageId)))
+				ebs.VolumeSize = aws.Int32(minSize)
+			}
+		case ebs.SnapshotId == nil && ebs.VolumeSize == nil:
+			return nil, nil, fmt.Errorf("block device %s has no snapshot in image %s and no volume size",
+				device, aws.ToString(newImage.ImageId))
+		}
+
+		out[i] = m
+	}
+
+	return out, changes, nil
+}
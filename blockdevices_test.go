@@ -0,0 +1,94 @@
+package ead
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestReconcileBlockDeviceMappings(t *testing.T) {
+	oldImage := ec2types.Image{
+		ImageId:        aws.String("ami-old"),
+		RootDeviceName: aws.String("/dev/xvda"),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-old"), VolumeSize: aws.Int32(30)}},
+		},
+	}
+	newImage := ec2types.Image{
+		ImageId:        aws.String("ami-new"),
+		RootDeviceName: aws.String("/dev/sda1"),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-new"), VolumeSize: aws.Int32(40)}},
+		},
+	}
+
+	root := func(device string, size int32) ec2types.LaunchTemplateBlockDeviceMappingRequest {
+		return ec2types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: aws.String(device),
+			Ebs: &ec2types.LaunchTemplateEbsBlockDeviceRequest{
+				SnapshotId: aws.String("snap-old"),
+				VolumeSize: aws.Int32(size),
+			},
+		}
+	}
+	data := ec2types.LaunchTemplateBlockDeviceMappingRequest{
+		DeviceName: aws.String("/dev/xvdb"),
+		Ebs: &ec2types.LaunchTemplateEbsBlockDeviceRequest{
+			SnapshotId: aws.String("snap-data"),
+			VolumeSize: aws.Int32(100),
+		},
+	}
+
+	t.Run("rename root and grow to minimum", func(t *testing.T) {
+		mappings := []ec2types.LaunchTemplateBlockDeviceMappingRequest{root("/dev/xvda", 30), data}
+		got, changes, err := reconcileBlockDeviceMappings(mappings, oldImage, newImage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 2 {
+			t.Errorf("got changes %q, want 2", changes)
+		}
+		if *got[0].DeviceName != "/dev/sda1" || *got[0].Ebs.VolumeSize != 40 || got[0].Ebs.SnapshotId != nil {
+			t.Errorf("root mapping is %s, %d GiB, snapshot %v", *got[0].DeviceName, *got[0].Ebs.VolumeSize, got[0].Ebs.SnapshotId)
+		}
+		if *got[1].DeviceName != "/dev/xvdb" || *got[1].Ebs.SnapshotId != "snap-data" || *got[1].Ebs.VolumeSize != 100 {
+			t.Error("data volume should be kept as it is")
+		}
+		if *mappings[0].DeviceName != "/dev/xvda" || mappings[0].Ebs.SnapshotId == nil {
+			t.Error("original mappings should not be modified")
+		}
+	})
+
+	t.Run("larger root volume is kept", func(t *testing.T) {
+		got, _, err := reconcileBlockDeviceMappings([]ec2types.LaunchTemplateBlockDeviceMappingRequest{root("/dev/xvda", 50)}, oldImage, newImage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got[0].Ebs.VolumeSize != 50 {
+			t.Errorf("root volume is %d GiB, want 50", *got[0].Ebs.VolumeSize)
+		}
+	})
+
+	t.Run("data volume on new root device", func(t *testing.T) {
+		conflict := data
+		conflict.DeviceName = aws.String("/dev/sda1")
+		_, _, err := reconcileBlockDeviceMappings([]ec2types.LaunchTemplateBlockDeviceMappingRequest{root("/dev/xvda", 30), conflict},
+			oldImage, newImage)
+		if err == nil {
+			t.Error("expected an error for a data volume on the new root device")
+		}
+	})
+
+	t.Run("old image volume without size", func(t *testing.T) {
+		sameRoot := newImage
+		sameRoot.RootDeviceName = aws.String("/dev/xvda")
+		sameRoot.BlockDeviceMappings = nil
+		m := root("/dev/xvda", 0)
+		m.Ebs.VolumeSize = nil
+		_, _, err := reconcileBlockDeviceMappings([]ec2types.LaunchTemplateBlockDeviceMappingRequest{m}, oldImage, sameRoot)
+		if err == nil {
+			t.Error("expected an error for a volume with no snapshot and no size")
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+)
+
+// standardVCPUQuotaCode is the EC2 quota for running On-Demand Standard (A, C, D, H, I, M, R, T, Z) instances
+const standardVCPUQuotaCode = "L-1216C47A"
+
+// standardInstanceFamilies are the instance families counted against standardVCPUQuotaCode
+const standardInstanceFamilies = "acdhimrtz"
+
+// capacityLimits is how much room there is for new instances. A negative value means the limit is unknown.
+type capacityLimits struct {
+	vCPUsPerInstance int
+	availableVCPUs   int
+	availableIPs     int
+	asgHeadroom      int
+}
+
+// maxInstances returns how many new instances fit within the limits, or -1 if there is no known limit
+func (c capacityLimits) maxInstances() int {
+	limit := -1
+	lower := func(n int) {
+		if n >= 0 && (limit < 0 || n < limit) {
+			limit = n
+		}
+	}
+	if c.vCPUsPerInstance > 0 && c.availableVCPUs >= 0 {
+		lower(c.availableVCPUs / c.vCPUsPerInstance)
+	}
+	lower(c.availableIPs)
+	lower(c.asgHeadroom)
+	return limit
+}
+
+// String describes the limits for error messages
+func (c capacityLimits) String() string {
+	var parts []string
+	if c.availableVCPUs >= 0 {
+		parts = append(parts, fmt.Sprintf("%d vCPUs available in quota at %d vCPUs per instance", c.availableVCPUs, c.vCPUsPerInstance))
+	}
+	if c.availableIPs >= 0 {
+		parts = append(parts, fmt.Sprintf("%d free IP addresses in the ASG's subnets", c.availableIPs))
+	}
+	if c.asgHeadroom >= 0 {
+		parts = append(parts, fmt.Sprintf("room for %d more instances below the ASG's max size", c.asgHeadroom))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// chooseBatchSize returns the number of instances to replace at a time. A requested size of zero replaces
+// all instances at once. If the limits don't allow the requested size, an error is returned, or with the
+// auto capacity policy, the largest batch that fits is used instead.
+func chooseBatchSize(total, requested int, limits capacityLimits, policy CapacityPolicy) (int, error) {
+	size := requested
+	if size <= 0 || size > total {
+		size = total
+	}
+
+	limit := limits.maxInstances()
+	if limit < 0 || size <= limit {
+		return size, nil
+	}
+
+	if policy != CapacityPolicyAuto {
+		return 0, fmt.Errorf("replacing %d instances at a time exceeds capacity limits: %s", size, limits)
+	}
+	if limit == 0 {
+		return 0, fmt.Errorf("no capacity to launch any replacement instances: %s", limits)
+	}
+	return limit, nil
+}
+
+// getCapacityLimits checks the vCPU quota, the free IP addresses in the ASG's subnets, and, if asgGrowth is
+// set, the ASG's max size. Detached instances no longer count towards the ASG's size, so their replacements
+// keep the desired capacity unchanged and the max size only limits batches when the replacements are added on
+// top of the existing instances. Limits that can't be determined are logged and reported as unknown.
+func (u *Upgrader) getCapacityLimits(asg *asgTypes.AutoScalingGroup, instanceTypes []ec2types.InstanceType,
+	asgGrowth bool) capacityLimits {
+
+	limits := capacityLimits{availableVCPUs: -1, availableIPs: -1, asgHeadroom: -1}
+
+	if asgGrowth {
+		limits.asgHeadroom = int(aws.ToInt32(asg.MaxSize) - aws.ToInt32(asg.DesiredCapacity))
+	}
+
+	vCPUs, err := u.getInstanceTypeVCPUs(instanceTypes)
+	if err != nil {
+		u.logger.Printf("Unable to check vCPU quota: %s", err)
+	} else {
+		for _, t := range instanceTypes {
+			if isStandardInstanceType(t) && vCPUs[t] > limits.vCPUsPerInstance {
+				limits.vCPUsPerInstance = vCPUs[t]
+			}
+		}
+		if limits.vCPUsPerInstance > 0 {
+			available, err := u.getAvailableStandardVCPUs()
+			if err != nil {
+				u.logger.Printf("Unable to check vCPU quota: %s", err)
+			} else {
+				limits.availableVCPUs = available
+			}
+		}
+	}
+
+	if subnets := aws.ToString(asg.VPCZoneIdentifier); subnets != "" {
+		ips, err := u.getAvailableIPs(strings.Split(subnets, ","))
+		if err != nil {
+			u.logger.Printf("Unable to check free IP addresses: %s", err)
+		} else {
+			limits.availableIPs = ips
+		}
+	}
+
+	return limits
+}
+
+// getAvailableStandardVCPUs returns the standard vCPU quota minus the vCPUs of running On-Demand standard
+// instances
+func (u *Upgrader) getAvailableStandardVCPUs() (int, error) {
+	quota, err := servicequotas.NewFromConfig(u.awsCfg).GetServiceQuota(context.Background(),
+		&servicequotas.GetServiceQuotaInput{
+			ServiceCode: aws.String("ec2"),
+			QuotaCode:   aws.String(standardVCPUQuotaCode),
+		})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get vCPU quota: %w", err)
+	}
+
+	var running []ec2types.InstanceType
+	paginator := ec2.NewDescribeInstancesPaginator(u.ec2Client, &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return 0, fmt.Errorf("failed to describe instances: %w", err)
+		}
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				if i.InstanceLifecycle != ec2types.InstanceLifecycleTypeSpot && isStandardInstanceType(i.InstanceType) {
+					running = append(running, i.InstanceType)
+				}
+			}
+		}
+	}
+
+	vCPUs, err := u.getInstanceTypeVCPUs(running)
+	if err != nil {
+		return 0, err
+	}
+	used := 0
+	for _, t := range running {
+		used += vCPUs[t]
+	}
+
+	return int(aws.ToFloat64(quota.Quota.Value)) - used, nil
+}
+
+// getInstanceTypeVCPUs returns the default number of vCPUs for each of the instance types
+func (u *Upgrader) getInstanceTypeVCPUs(types []ec2types.InstanceType) (map[ec2types.InstanceType]int, error) {
+	vCPUs := map[ec2types.InstanceType]int{}
+
+	var distinct []ec2types.InstanceType
+	for _, t := range types {
+		if _, ok := vCPUs[t]; !ok {
+			vCPUs[t] = 0
+			distinct = append(distinct, t)
+		}
+	}
+
+	for start := 0; start < len(distinct); start += 100 {
+		end := min(start+100, len(distinct))
+		result, err := u.ec2Client.DescribeInstanceTypes(context.Background(), &ec2.DescribeInstanceTypesInput{
+			InstanceTypes: distinct[start:end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe instance types: %w", err)
+		}
+		for _, info := range result.InstanceTypes {
+			if info.VCpuInfo != nil {
+				vCPUs[info.InstanceType] = int(aws.ToInt32(info.VCpuInfo.DefaultVCpus))
+			}
+		}
+	}
+
+	return vCPUs, nil
+}
+
+// getAvailableIPs returns the total number of free IP addresses in the subnets
+func (u *Upgrader) getAvailableIPs(subnetIDs []string) (int, error) {
+	result, err := u.ec2Client.DescribeSubnets(context.Background(), &ec2.DescribeSubnetsInput{SubnetIds: subnetIDs})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe subnets: %w", err)
+	}
+
+	available := 0
+	for _, s := range result.Subnets {
+		available += int(aws.ToInt32(s.AvailableIpAddressCount))
+	}
+	return available, nil
+}
+
+// isStandardInstanceType is true for instance types counted against the standard vCPU quota
+func isStandardInstanceType(t ec2types.InstanceType) bool {
+	name := string(t)
+	return name != "" && strings.ContainsRune(standardInstanceFamilies, rune(name[0])) &&
+		!strings.HasPrefix(name, "inf") && !strings.HasPrefix(name, "dl") && !strings.HasPrefix(name, "trn") &&
+		!strings.HasPrefix(name, "hpc")
+}
+
+// chooseBatchSize checks the capacity limits for launching instances of the types used by the ASG and the
+// new launch template data, and returns the number of instances to replace at a time
+func (u *Upgrader) chooseBatchSize(asg *asgTypes.AutoScalingGroup, ltData *ec2types.RequestLaunchTemplateData,
+	total int) (int, error) {
+
+	var instanceTypes []ec2types.InstanceType
+	if ltData.InstanceType != "" {
+		instanceTypes = append(instanceTypes, ltData.InstanceType)
+	}
+	if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		for _, t := range overrideInstanceTypes(asg.MixedInstancesPolicy) {
+			instanceTypes = append(instanceTypes, ec2types.InstanceType(t))
+		}
+	}
+
+	// when instances are kept in the ASG, each batch of replacements is added to its desired capacity
+	limits := u.getCapacityLimits(asg, instanceTypes, u.keepInstancesInASG)
+	size, err := chooseBatchSize(total, u.batchSize, limits, u.capacityPolicy)
+	if err != nil {
+		return 0, err
+	}
+	if size < total {
+		u.logger.Printf("Replacing %d instances at a time", size)
+	}
+	return size, nil
+}
+
+// instanceBatches splits the instances into batches of the given size
+func instanceBatches(instances []ecsTypes.ContainerInstance, size int) [][]ecsTypes.ContainerInstance {
+	if size <= 0 {
+		size = len(instances)
+	}
+	var batches [][]ecsTypes.ContainerInstance
+	for start := 0; start < len(instances); start += size {
+		batches = append(batches, instances[start:min(start+size, len(instances))])
+	}
+	return batches
+}
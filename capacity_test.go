@@ -0,0 +1,98 @@
+package ead
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestChooseBatchSize(t *testing.T) {
+	unknown := capacityLimits{availableVCPUs: -1, availableIPs: -1, asgHeadroom: -1}
+
+	tests := []struct {
+		name      string
+		requested int
+		limits    capacityLimits
+		policy    CapacityPolicy
+		want      int
+		wantErr   bool
+	}{
+		{name: "no limits", requested: 0, limits: unknown, policy: CapacityPolicyFail, want: 6},
+		{name: "requested batch", requested: 2, limits: unknown, policy: CapacityPolicyFail, want: 2},
+		{
+			name:   "fits",
+			limits: capacityLimits{vCPUsPerInstance: 2, availableVCPUs: 12, availableIPs: 100, asgHeadroom: -1},
+			policy: CapacityPolicyFail,
+			want:   6,
+		},
+		{
+			name:    "vCPU quota exceeded",
+			limits:  capacityLimits{vCPUsPerInstance: 4, availableVCPUs: 10, availableIPs: 100, asgHeadroom: -1},
+			policy:  CapacityPolicyFail,
+			wantErr: true,
+		},
+		{
+			name:   "vCPU quota exceeded with auto batches",
+			limits: capacityLimits{vCPUsPerInstance: 4, availableVCPUs: 10, availableIPs: 100, asgHeadroom: -1},
+			policy: CapacityPolicyAuto,
+			want:   2,
+		},
+		{
+			name:   "subnet IPs limit",
+			limits: capacityLimits{availableVCPUs: -1, availableIPs: 3, asgHeadroom: -1},
+			policy: CapacityPolicyAuto,
+			want:   3,
+		},
+		{
+			name:    "no capacity",
+			limits:  capacityLimits{availableVCPUs: -1, availableIPs: 0, asgHeadroom: -1},
+			policy:  CapacityPolicyAuto,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chooseBatchSize(6, tt.requested, tt.limits, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chooseBatchSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("chooseBatchSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstanceBatches(t *testing.T) {
+	instances := make([]ecsTypes.ContainerInstance, 5)
+	for i := range instances {
+		instances[i].Ec2InstanceId = aws.String(string(rune('a' + i)))
+	}
+
+	batches := instanceBatches(instances, 2)
+	if len(batches) != 3 || len(batches[0]) != 2 || len(batches[2]) != 1 || *batches[2][0].Ec2InstanceId != "e" {
+		t.Errorf("got batches %v", batches)
+	}
+	if batches := instanceBatches(instances, 0); len(batches) != 1 || len(batches[0]) != 5 {
+		t.Errorf("batch size 0 should put all instances in one batch, got %d batches", len(batches))
+	}
+}
+
+func TestIsStandardInstanceType(t *testing.T) {
+	for typ, want := range map[ec2types.InstanceType]bool{
+		"m5.large":      true,
+		"c7g.xlarge":    true,
+		"t3.micro":      true,
+		"g5.xlarge":     false,
+		"p4d.24xlarge":  false,
+		"inf1.xlarge":   false,
+		"x2gd.large":    false,
+		"hpc7g.4xlarge": false,
+	} {
+		if got := isStandardInstanceType(typ); got != want {
+			t.Errorf("isStandardInstanceType(%s) = %t, want %t", typ, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	ead "github.com/silinternational/ecs-ami-deploy/v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+var outputFormat string
+
+// diffAMICmd represents the diff-ami command
+var diffAMICmd = &cobra.Command{
+	Use:   "diff-ami",
+	Short: "Show differences between the cluster's current AMI and the latest AMI",
+	Long: "Command compares the AMI in the cluster's launch template with the latest AMI matching the filter, " +
+		"including ECS agent, Docker, containerd and kernel versions",
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsCfg()
+
+		upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{
+			Cluster:   cluster,
+			AMIFilter: AMIFilter,
+			AMIOwners: amiOwners,
+		}))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		diff, err := upgrader.DiffAMI()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if outputFormat == outputJSON {
+			printJSON(diff)
+			return
+		}
+		printAMIDiff(diff)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffAMICmd)
+
+	diffAMICmd.Flags().StringVar(&cluster, "cluster", "", "Cluster name")
+	_ = diffAMICmd.MarkFlagRequired("cluster")
+
+	diffAMICmd.Flags().StringVar(&AMIFilter, "ami-filter", ead.DefaultAMIFilter, "AMI search filter")
+	diffAMICmd.Flags().StringSliceVar(&amiOwners, "ami-owners", ead.DefaultAMIOwners, "AMI owners")
+	diffAMICmd.Flags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format, table or json")
+}
+
+func printAMIDiff(diff ead.AMIDiff) {
+	fmt.Printf("\nChanges from %s to %s:\n\n", diff.CurrentImageID, diff.TargetImageID)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	_, _ = fmt.Fprintln(w, "Attribute \t Current \t Target \t Changed?")
+	for _, f := range diff.Fields {
+		_, _ = fmt.Fprintf(w, "%s \t %s \t %s \t %t\n", f.Name, f.Current, f.Target, f.Changed)
+	}
+	_ = w.Flush()
+	fmt.Println("")
+}
+
+func printJSON(v any) {
+	jb, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(string(jb))
+}
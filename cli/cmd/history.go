@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	ead "github.com/silinternational/ecs-ami-deploy/v3"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the AMI upgrade history of the given ECS cluster",
+	Long: "Command lists the versions of the launch template used by the cluster's ASG, oldest first, " +
+		"including the AMIs, run ID and tool version of the versions created by ecs-ami-deploy",
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsCfg()
+
+		upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{Cluster: cluster}))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		history, err := upgrader.History()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if outputFormat == outputJSON {
+			printJSON(history)
+			return
+		}
+
+		fmt.Println("")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+		_, _ = fmt.Fprintln(w, "Version \t Created \t Image \t Previous Image \t Run ID \t Tool Version")
+		for _, h := range history {
+			previous, runID, toolVersion := "", "", ""
+			if p := h.Provenance; p != nil {
+				previous, runID, toolVersion = p.FromImageID, p.RunID, p.ToolVersion
+			}
+			_, _ = fmt.Fprintf(w, "%d \t %s \t %s \t %s \t %s \t %s\n", h.VersionNumber,
+				h.CreateTime.Format(time.RFC3339), h.ImageID, previous, runID, toolVersion)
+		}
+		_ = w.Flush()
+		fmt.Println("")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVar(&cluster, "cluster", "", "Cluster name")
+	_ = historyCmd.MarkFlagRequired("cluster")
+	historyCmd.Flags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format, table or json")
+}
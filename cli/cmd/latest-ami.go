@@ -20,7 +20,7 @@ var latestAMICmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		initAwsCfg()
 
-		upgrader, err := ead.NewUpgrader(AwsCfg, &ead.Config{AMIFilter: AMIFilter})
+		upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{AMIFilter: AMIFilter}))
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/cobra"
+
+	ead "github.com/silinternational/ecs-ami-deploy/v3"
+)
+
+var amiOwners []string
+
+// listAMIsCmd represents the list-amis command
+var listAMIsCmd = &cobra.Command{
+	Use:   "list-amis",
+	Short: "List all AMIs for filter in currently authenticated region",
+	Long: "Command returns every AMI matching the given filter and owners, newest first, along with the " +
+		"clusters whose launch templates use each one",
+	Run: func(cmd *cobra.Command, args []string) {
+		listAMIs()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listAMIsCmd)
+
+	listAMIsCmd.Flags().StringVar(&AMIFilter, "ami-filter", ead.DefaultAMIFilter, "AMI name filter")
+	listAMIsCmd.Flags().StringSliceVar(&amiOwners, "ami-owners", ead.DefaultAMIOwners, "AMI owners")
+	listAMIsCmd.Flags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format, table or json")
+}
+
+func listAMIs() {
+	initAwsCfg()
+
+	upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{AMIFilter: AMIFilter, AMIOwners: amiOwners}))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	images, err := upgrader.ListAMIs()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	clusters, err := upgrader.ListClusters()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	usage := ead.AMIUsage(images, clusters)
+	if outputFormat == outputJSON {
+		printJSON(usage)
+		return
+	}
+
+	fmt.Printf("\nFound %d AMIs for filter \"%s\"\n\n", len(usage), AMIFilter)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	_, _ = fmt.Fprintln(w, "AMI \t Name \t Released \t Deprecation Time \t Used By Clusters")
+	for _, a := range usage {
+		_, _ = fmt.Fprintf(w, "%s \t %s \t %s \t %s \t %s\n", *a.Image.ImageId, aws.ToString(a.Image.Name),
+			aws.ToString(a.Image.CreationDate), aws.ToString(a.Image.DeprecationTime), strings.Join(a.Clusters, ", "))
+	}
+	_ = w.Flush()
+	fmt.Println("")
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"text/tabwriter"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	ead "github.com/silinternational/ecs-ami-deploy/v3"
 	"github.com/spf13/cobra"
 )
@@ -38,7 +39,12 @@ func init() {
 func listClusters() {
 	initAwsCfg()
 
-	upgrader, err := ead.NewUpgrader(AwsCfg, &ead.Config{AMIFilter: AMIFilter})
+	if fleet := newFleet(&ead.Config{AMIFilter: AMIFilter}); fleet != nil {
+		listFleetClusters(fleet)
+		return
+	}
+
+	upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{AMIFilter: AMIFilter}))
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -67,3 +73,28 @@ func listClusters() {
 	_ = w.Flush()
 	fmt.Println("")
 }
+
+func listFleetClusters(fleet *ead.Fleet) {
+	// results from regions that failed are left out, so report errors but keep going
+	latestAMIs, err := fleet.LatestAMIs()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	list, err := fleet.ListClusters()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	fmt.Println("")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	_, _ = fmt.Fprintln(w, "Account \t Region \t Cluster \t Current AMI \t Released \t Is Latest AMI?")
+
+	for _, c := range list {
+		isLatest := aws.ToString(latestAMIs[c.Account][c.Region].ImageId) == *c.Image.ImageId
+		_, _ = fmt.Fprintf(w, "%s \t %s \t %s \t %s \t %s \t %t\n", c.Account, c.Region, *c.Cluster.ClusterName,
+			*c.Image.Name, *c.Image.CreationDate, isLatest)
+	}
+	_ = w.Flush()
+	fmt.Println("")
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	ead "github.com/silinternational/ecs-ami-deploy/v3"
+)
+
+// migrateLaunchConfigCmd represents the migrate-launch-config command
+var migrateLaunchConfigCmd = &cobra.Command{
+	Use:   "migrate-launch-config",
+	Short: "Convert the launch configuration of the given ECS cluster's ASG into a launch template",
+	Long: "Command creates a launch template equivalent to the launch configuration used by the cluster's ASG and " +
+		"updates the ASG to use it, so that upgrade-cluster can be used on the cluster",
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsCfg()
+
+		upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{
+			Cluster:                  cluster,
+			LaunchTemplateNamePrefix: launchTemplateNamePrefix,
+		}))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		lt, err := upgrader.MigrateLaunchConfiguration()
+		if err != nil {
+			fmt.Printf("Error migrating launch configuration: %s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("\nCluster %s now uses launch template %s (%s)\n", cluster, *lt.LaunchTemplateName, *lt.LaunchTemplateId)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateLaunchConfigCmd)
+
+	migrateLaunchConfigCmd.Flags().StringVar(&cluster, "cluster", "", "Cluster name")
+	_ = migrateLaunchConfigCmd.MarkFlagRequired("cluster")
+
+	migrateLaunchConfigCmd.Flags().StringVar(&launchTemplateNamePrefix, "launch-template-name-prefix",
+		"", "Launch template name prefix")
+}
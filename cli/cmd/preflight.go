@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	ead "github.com/silinternational/ecs-ami-deploy/v3"
+)
+
+// preflightCmd represents the preflight command
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check the given ECS cluster for services and tasks that would suffer downtime during an upgrade",
+	Long: "Command inspects every service and task in the cluster and lists the risks of replacing its " +
+		"instances. It exits with status 1 if any high risk findings exist.",
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsCfg()
+
+		upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{Cluster: cluster}))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		report, err := upgrader.Preflight()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if outputFormat == outputJSON {
+			printJSON(report)
+		} else if len(report.Findings) == 0 {
+			fmt.Printf("\nNo risks found in cluster %s\n\n", cluster)
+		} else {
+			fmt.Println("")
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+			_, _ = fmt.Fprintln(w, "Severity \t Resource \t Finding")
+			for _, f := range report.Findings {
+				_, _ = fmt.Fprintf(w, "%s \t %s \t %s\n", f.Severity, f.Resource, f.Message)
+			}
+			_ = w.Flush()
+			fmt.Println("")
+		}
+
+		if report.HasHighRisk() {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+
+	preflightCmd.Flags().StringVar(&cluster, "cluster", "", "Cluster name")
+	_ = preflightCmd.MarkFlagRequired("cluster")
+	preflightCmd.Flags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format, table or json")
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,13 +13,23 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/spf13/viper"
+
+	ead "github.com/silinternational/ecs-ami-deploy/v3"
 )
 
 var (
-	AwsCfg  aws.Config
-	cfgFile string
-	Profile string
-	Region  string
+	AwsCfg            aws.Config
+	cfgFile           string
+	Profile           string
+	Region            string
+	Regions           []string
+	RegionConcurrency int
+	RoleARN           string
+	ExternalID        string
+	UseFleetTargets   bool
+	CallsPerSecond    float64
+	MaxRetryAttempts  int
+	MaxRetryBackoff   int
 
 	// The following vars are updated by build process
 
@@ -54,6 +65,23 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.ecs-ami-deploy.yaml)")
 	rootCmd.PersistentFlags().StringVarP(&Profile, "profile", "p", "", "AWS shared credentials profile to use")
 	rootCmd.PersistentFlags().StringVarP(&Region, "region", "r", "us-east-1", "AWS region")
+	rootCmd.PersistentFlags().StringSliceVar(&Regions, "regions", nil,
+		`AWS regions for list-clusters and upgrade-cluster, or "all" for every enabled region. Overrides --region `+
+			`for those commands and is ignored by the others`)
+	rootCmd.PersistentFlags().IntVar(&RegionConcurrency, "region-concurrency", ead.DefaultFleetConcurrency,
+		"Number of regions to run at the same time with --regions")
+	rootCmd.PersistentFlags().StringVar(&RoleARN, "role-arn", "",
+		"IAM role to assume for fleet commands. Additional accounts can be listed under targets in the config file "+
+			"and used with --fleet")
+	rootCmd.PersistentFlags().StringVar(&ExternalID, "external-id", "", "External ID to use when assuming --role-arn")
+	rootCmd.PersistentFlags().BoolVar(&UseFleetTargets, "fleet", false,
+		"Also run list-clusters and upgrade-cluster in the accounts listed under targets in the config file")
+	rootCmd.PersistentFlags().Float64Var(&CallsPerSecond, "calls-per-second", 0,
+		"Budget for AWS API calls, shared by all regions and accounts of fleet commands. 0 doesn't limit calls")
+	rootCmd.PersistentFlags().IntVar(&MaxRetryAttempts, "max-retry-attempts", ead.DefaultMaxRetryAttempts,
+		"Maximum attempts for throttled or failed AWS API calls")
+	rootCmd.PersistentFlags().IntVar(&MaxRetryBackoff, "max-retry-backoff-seconds",
+		int(ead.DefaultMaxRetryBackoff.Seconds()), "Maximum number of seconds between attempts of an AWS API call")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -105,3 +133,76 @@ func initAwsCfg() {
 		log.Printf("failed to load config with profile %s", Profile)
 	}
 }
+
+// apiConfig sets the AWS API call budget and retry options on the config
+func apiConfig(config *ead.Config) *ead.Config {
+	config.CallsPerSecond = CallsPerSecond
+	config.MaxRetryAttempts = MaxRetryAttempts
+	config.MaxRetryBackoff = time.Duration(MaxRetryBackoff) * time.Second
+	return config
+}
+
+// target is an entry in the targets list of the config file, for example:
+//
+//	targets:
+//	  - role_arn: arn:aws:iam::111111111111:role/ecs-ami-deploy
+//	    external_id: abc123
+//	    regions: [us-east-1, eu-west-1]
+//	    clusters: [prod, staging]
+type target struct {
+	RoleARN    string   `mapstructure:"role_arn"`
+	ExternalID string   `mapstructure:"external_id"`
+	Regions    []string `mapstructure:"regions"`
+	Clusters   []string `mapstructure:"clusters"`
+}
+
+// fleetTargets returns the targets from the --role-arn flag, and from the config file if --fleet is set
+func fleetTargets() []ead.Target {
+	var targets []ead.Target
+	if RoleARN != "" {
+		targets = append(targets, ead.Target{RoleARN: RoleARN, ExternalID: ExternalID})
+	}
+	if !UseFleetTargets {
+		return targets
+	}
+
+	var configTargets []target
+	if err := viper.UnmarshalKey("targets", &configTargets); err != nil {
+		fmt.Printf("invalid targets in config file: %s\n", err)
+		os.Exit(1)
+	}
+	for _, t := range configTargets {
+		targets = append(targets, ead.Target{
+			RoleARN:    t.RoleARN,
+			ExternalID: t.ExternalID,
+			Regions:    t.Regions,
+			Clusters:   t.Clusters,
+		})
+	}
+	if len(configTargets) == 0 {
+		fmt.Println("--fleet requires targets in the config file")
+		os.Exit(1)
+	}
+
+	return targets
+}
+
+// newFleet returns a Fleet for the regions given with --regions and the targets given with --role-arn or
+// --fleet, or nil if there are neither
+func newFleet(config *ead.Config) *ead.Fleet {
+	targets := fleetTargets()
+	if len(Regions) == 0 && len(targets) == 0 {
+		return nil
+	}
+
+	fleet, err := ead.NewFleet(AwsCfg, apiConfig(config), &ead.FleetConfig{
+		Concurrency: RegionConcurrency,
+		Regions:     Regions,
+		Targets:     targets,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return fleet
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	ead "github.com/silinternational/ecs-ami-deploy/v3"
+)
+
+// unlockCmd represents the unlock command
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove the upgrade lock from the given ECS cluster",
+	Long: "Command removes the upgrade lock from the cluster's ASG, no matter which run holds it. Use it only " +
+		"when the run holding the lock is known to have stopped.",
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsCfg()
+
+		upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(&ead.Config{Cluster: cluster}))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		lease, err := upgrader.Unlock()
+		if err != nil {
+			fmt.Printf("Error removing lock: %s\n", err)
+			os.Exit(1)
+		}
+
+		if lease == nil {
+			fmt.Printf("\nCluster %s was not locked\n\n", cluster)
+			return
+		}
+		fmt.Printf("\nRemoved lock on cluster %s held by %s (run %s), which expires at %s\n\n", cluster,
+			lease.Owner, lease.RunID, lease.Expires.Format(time.RFC3339))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+
+	unlockCmd.Flags().StringVar(&cluster, "cluster", "", "Cluster name")
+	_ = unlockCmd.MarkFlagRequired("cluster")
+}
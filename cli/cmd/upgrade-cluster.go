@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -11,12 +13,46 @@ import (
 )
 
 var (
+	abortOnHighRisk          bool
+	batchSize                int
+	capacityPolicy           string
 	cluster                  string
+	defaultVersionPolicy     string
+	deploymentInterval       int
+	deploymentTimeout        int
+	dryRun                   bool
+	failurePolicy            string
 	forceReplace             bool
+	inServiceInterval        int
+	inServiceTimeout         int
+	instanceType             string
+	instanceTypeOverrides    []string
+	keepInstancesInASG       bool
 	launchTemplateNamePrefix string
 	launchTemplateLimit      int
+	launchTemplateRetention  int
+	lifecycleHookTimeout     int
+	minimumAgentVersion      string
+	minimumIntervalsStable   int
+	minimumRegisteredCPU     int32
+	minimumRegisteredMemory  int32
 	pollingInterval          int
 	pollingTimeout           int
+	registrationInterval     int
+	registrationTimeout      int
+	requiredAttributes       []string
+	resourceFitTimeout       int
+	skipAMIUpgrade           bool
+	stabilityInterval        int
+	stabilityTimeout         int
+	standaloneTaskPolicy     string
+	standaloneTaskTimeout    int
+	suspendReplaceUnhealthy  bool
+	taskFailurePolicy        string
+	taskFailureThreshold     int
+	userDataTemplate         string
+	warmPoolInterval         int
+	warmPoolTimeout          int
 )
 
 // latestAMICmd represents the ec2 latest-ami command
@@ -27,20 +63,93 @@ var upgradeClusterCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		initAwsCfg()
 
-		upgrader, err := ead.NewUpgrader(AwsCfg, &ead.Config{
-			Cluster:                  cluster,
-			AMIFilter:                AMIFilter,
-			ForceReplacement:         forceReplace,
-			LaunchTemplateNamePrefix: launchTemplateNamePrefix,
-			LaunchTemplateLimit:      launchTemplateLimit,
-			PollingInterval:          time.Duration(pollingInterval) * time.Second,
-			PollingTimeout:           time.Duration(pollingTimeout) * time.Minute,
-		})
+		config := &ead.Config{
+			AbortOnHighRisk:            abortOnHighRisk,
+			BatchSize:                  batchSize,
+			CapacityPolicy:             ead.CapacityPolicy(capacityPolicy),
+			Cluster:                    cluster,
+			AMIFilter:                  AMIFilter,
+			AMIOwners:                  amiOwners,
+			DefaultVersionPolicy:       ead.DefaultVersionPolicy(defaultVersionPolicy),
+			DeploymentInterval:         time.Duration(deploymentInterval) * time.Second,
+			DeploymentTimeout:          time.Duration(deploymentTimeout) * time.Minute,
+			FailurePolicy:              ead.FailurePolicy(failurePolicy),
+			ForceReplacement:           forceReplace,
+			InServiceInterval:          time.Duration(inServiceInterval) * time.Second,
+			InServiceTimeout:           time.Duration(inServiceTimeout) * time.Minute,
+			InstanceType:               instanceType,
+			InstanceTypeOverrides:      instanceTypeOverrides,
+			KeepInstancesInASG:         keepInstancesInASG,
+			LaunchTemplateNamePrefix:   launchTemplateNamePrefix,
+			LaunchTemplateLimit:        launchTemplateLimit,
+			LaunchTemplateRetentionAge: time.Duration(launchTemplateRetention) * 24 * time.Hour,
+			LifecycleHookTimeout:       time.Duration(lifecycleHookTimeout) * time.Minute,
+			MinimumAgentVersion:        minimumAgentVersion,
+			MinimumIntervalsForStable:  minimumIntervalsStable,
+			MinimumRegisteredCPU:       minimumRegisteredCPU,
+			MinimumRegisteredMemory:    minimumRegisteredMemory,
+			PollingInterval:            time.Duration(pollingInterval) * time.Second,
+			PollingTimeout:             time.Duration(pollingTimeout) * time.Minute,
+			RegistrationInterval:       time.Duration(registrationInterval) * time.Second,
+			RegistrationTimeout:        time.Duration(registrationTimeout) * time.Minute,
+			RequiredAttributes:         requiredAttributes,
+			ResourceFitTimeout:         time.Duration(resourceFitTimeout) * time.Minute,
+			SkipAMIUpgrade:             skipAMIUpgrade,
+			StabilityInterval:          time.Duration(stabilityInterval) * time.Second,
+			StabilityTimeout:           time.Duration(stabilityTimeout) * time.Minute,
+			StandaloneTaskPolicy:       ead.StandaloneTaskPolicy(standaloneTaskPolicy),
+			StandaloneTaskTimeout:      time.Duration(standaloneTaskTimeout) * time.Minute,
+			SuspendReplaceUnhealthy:    suspendReplaceUnhealthy,
+			TaskFailurePolicy:          ead.TaskFailurePolicy(taskFailurePolicy),
+			TaskFailureThreshold:       taskFailureThreshold,
+			ToolVersion:                Version,
+			WarmPoolInterval:           time.Duration(warmPoolInterval) * time.Second,
+			WarmPoolTimeout:            time.Duration(warmPoolTimeout) * time.Minute,
+		}
+
+		if userDataTemplate != "" {
+			text, err := os.ReadFile(userDataTemplate)
+			if err != nil {
+				fmt.Printf("failed to read user data template: %s\n", err)
+				os.Exit(1)
+			}
+			mutator, err := ead.UserDataTemplateMutator(string(text))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			config.LaunchTemplateMutators = append(config.LaunchTemplateMutators, mutator)
+		}
+
+		if fleet := newFleet(config); fleet != nil {
+			upgradeFleet(fleet)
+		}
+
+		if cluster == "" {
+			fmt.Println(`required flag "cluster" not set`)
+			os.Exit(1)
+		}
+
+		upgrader, err := ead.NewUpgrader(AwsCfg, apiConfig(config))
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
+		if dryRun {
+			plan, err := upgrader.PlanUpgrade()
+			if err != nil {
+				fmt.Printf("Error planning cluster upgrade: %s", err)
+				os.Exit(1)
+			}
+			if outputFormat == outputJSON {
+				printJSON(plan)
+			} else {
+				printPlan(plan)
+			}
+			os.Exit(0)
+		}
+
 		if err := upgrader.UpgradeCluster(); err != nil {
 			fmt.Printf("Error upgrading cluster: %s", err)
 			os.Exit(1)
@@ -62,8 +171,9 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// ecsListInstanceIPsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	upgradeClusterCmd.PersistentFlags().StringVar(&cluster, "cluster", "", "Cluster name")
-	_ = upgradeClusterCmd.MarkPersistentFlagRequired("cluster")
+	upgradeClusterCmd.PersistentFlags().StringVar(&cluster, "cluster", "",
+		"Cluster name. With --fleet it limits the targets to this cluster, and can be left out if every target "+
+			"lists its clusters")
 
 	upgradeClusterCmd.PersistentFlags().StringVar(&launchTemplateNamePrefix, "launch-template-name-prefix",
 		"", "Launch template name prefix")
@@ -71,10 +181,194 @@ func init() {
 		false, "Force replacement if current AMI is already latest")
 	upgradeClusterCmd.PersistentFlags().StringVar(&AMIFilter, "ami-filter",
 		ead.DefaultAMIFilter, "AMI search filter")
+	upgradeClusterCmd.PersistentFlags().StringSliceVar(&amiOwners, "ami-owners",
+		ead.DefaultAMIOwners, "AMI owners")
+	upgradeClusterCmd.PersistentFlags().StringVar(&defaultVersionPolicy, "default-version-policy",
+		string(ead.DefaultVersionPolicyAlways), `When to make the new launch template version the default: "always", `+
+			`"auto" (only if the ASG uses $Default), or "never"`)
 	upgradeClusterCmd.PersistentFlags().IntVar(&launchTemplateLimit, "launch-template-limit",
-		ead.DefaultLaunchTemplateLimit, "Number of previous launch template versions to keep.")
+		ead.DefaultLaunchTemplateLimit, "Number of previous versions to keep for each launch template.")
+	upgradeClusterCmd.PersistentFlags().IntVar(&launchTemplateRetention, "launch-template-retention-days",
+		0, "Keep launch template versions younger than this many days, even beyond the launch template limit.")
 	upgradeClusterCmd.PersistentFlags().IntVar(&pollingInterval, "polling-interval-seconds",
 		int(ead.DefaultPollingInterval.Seconds()), "Number of seconds between status checks.")
 	upgradeClusterCmd.PersistentFlags().IntVar(&pollingTimeout, "polling-timeout-minutes",
 		int(ead.DefaultPollingTimeout.Minutes()), "Number of minutes before a polling operation times out.")
+	upgradeClusterCmd.PersistentFlags().IntVar(&inServiceInterval, "in-service-interval-seconds",
+		0, "Seconds between checks for new instances in service with the ASG. Defaults to a backoff of 15 to 120 seconds")
+	upgradeClusterCmd.PersistentFlags().IntVar(&inServiceTimeout, "in-service-timeout-minutes",
+		0, "Minutes to wait for new instances to be in service with the ASG. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().IntVar(&registrationInterval, "registration-interval-seconds",
+		0, "Seconds between checks for new instances registered with the cluster. Defaults to the polling interval")
+	upgradeClusterCmd.PersistentFlags().IntVar(&registrationTimeout, "registration-timeout-minutes",
+		0, "Minutes to wait for new instances to register with the cluster. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().IntVar(&stabilityInterval, "stability-interval-seconds",
+		0, "Seconds between checks for pending tasks in the cluster. Defaults to the polling interval")
+	upgradeClusterCmd.PersistentFlags().IntVar(&stabilityTimeout, "stability-timeout-minutes",
+		0, "Minutes to wait for the cluster to have no pending tasks. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().IntVar(&deploymentInterval, "deployment-interval-seconds",
+		0, "Seconds between checks for completed service deployments. Defaults to the polling interval")
+	upgradeClusterCmd.PersistentFlags().IntVar(&deploymentTimeout, "deployment-timeout-minutes",
+		0, "Minutes to wait for service deployments to complete. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().IntVar(&warmPoolInterval, "warm-pool-interval-seconds",
+		0, "Seconds between checks for the ASG's warm pool to be deleted. Defaults to the polling interval")
+	upgradeClusterCmd.PersistentFlags().IntVar(&warmPoolTimeout, "warm-pool-timeout-minutes",
+		0, "Minutes to wait for the ASG's warm pool to be deleted. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().IntVar(&minimumIntervalsStable, "minimum-intervals-for-stable",
+		ead.MinimumIntervalsForStable, "Number of checks in a row without pending tasks before the cluster is stable")
+	upgradeClusterCmd.PersistentFlags().StringVar(&instanceType, "instance-type",
+		"", "New instance type for the launch template. Instances are replaced even if the AMI is already current. "+
+			"Not allowed for ASGs with mixed instances policy overrides, use --instance-type-overrides instead")
+	upgradeClusterCmd.PersistentFlags().StringSliceVar(&instanceTypeOverrides, "instance-type-overrides",
+		nil, "New instance types for the ASG's mixed instances policy overrides, in priority order")
+	upgradeClusterCmd.PersistentFlags().BoolVar(&skipAMIUpgrade, "skip-ami-upgrade",
+		false, "Keep the current AMI and only change the instance type")
+	upgradeClusterCmd.PersistentFlags().StringVar(&userDataTemplate, "user-data-template", "",
+		"File containing a Go template for the user data of the new launch template version. "+
+			"It can use {{.Cluster}}, {{.OldImage}}, {{.NewImage}} and the current {{.UserData}}")
+	upgradeClusterCmd.PersistentFlags().BoolVar(&abortOnHighRisk, "abort-on-high-risk",
+		false, "Refuse to upgrade if the preflight checks find high risk services or tasks")
+	upgradeClusterCmd.PersistentFlags().IntVar(&batchSize, "batch-size",
+		0, "Number of instances to replace at a time. 0 replaces all instances at once")
+	upgradeClusterCmd.PersistentFlags().StringVar(&capacityPolicy, "capacity-policy",
+		string(ead.CapacityPolicyFail), `What to do if the vCPU quota, free subnet IPs, or with `+
+			`--keep-instances-in-asg the ASG max size, can't fit the batch: "fail" before making changes, or "auto" `+
+			`to use smaller batches`)
+	upgradeClusterCmd.PersistentFlags().BoolVar(&suspendReplaceUnhealthy, "suspend-replace-unhealthy",
+		false, "Also suspend the ASG's ReplaceUnhealthy process during the upgrade")
+	upgradeClusterCmd.PersistentFlags().StringVar(&failurePolicy, "failure-policy",
+		string(ead.FailurePolicyRestore), `What to do if replacing instances fails before any old instance is `+
+			`deregistered: "restore" the ASG and its old instances, or "none" to leave them for the next run`)
+	upgradeClusterCmd.PersistentFlags().StringVar(&standaloneTaskPolicy, "standalone-task-policy",
+		string(ead.StandaloneTaskPolicyProceed), `What to do with old instances running tasks that don't belong to a `+
+			`service: "proceed" to stop the tasks, "wait" for them to finish, or "skip" the instance until a later run`)
+	upgradeClusterCmd.PersistentFlags().IntVar(&standaloneTaskTimeout, "standalone-task-timeout-minutes",
+		0, "Minutes to wait for standalone tasks with --standalone-task-policy wait. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().BoolVar(&keepInstancesInASG, "keep-instances-in-asg",
+		false, "Scale the ASG out instead of detaching old instances, and terminate them through the ASG so that "+
+			"lifecycle hooks run")
+	upgradeClusterCmd.PersistentFlags().IntVar(&lifecycleHookTimeout, "lifecycle-hook-timeout-minutes",
+		0, "Minutes to wait for termination lifecycle hooks with --keep-instances-in-asg. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().StringVar(&minimumAgentVersion, "minimum-agent-version",
+		"", "Oldest ECS agent version that new container instances may run")
+	upgradeClusterCmd.PersistentFlags().StringSliceVar(&requiredAttributes, "required-attributes",
+		nil, "Attributes new container instances must have, as name or name=value")
+	upgradeClusterCmd.PersistentFlags().Int32Var(&minimumRegisteredCPU, "minimum-registered-cpu",
+		0, "Least CPU units new container instances must register")
+	upgradeClusterCmd.PersistentFlags().Int32Var(&minimumRegisteredMemory, "minimum-registered-memory",
+		0, "Least memory in MiB new container instances must register")
+	upgradeClusterCmd.PersistentFlags().IntVar(&resourceFitTimeout, "resource-fit-timeout-minutes",
+		0, "Minutes to wait for an old instance's tasks to fit on the rest of the cluster. Defaults to the polling timeout")
+	upgradeClusterCmd.PersistentFlags().StringVar(&taskFailurePolicy, "task-failure-policy",
+		string(ead.TaskFailurePolicyAbort), `What to do once tasks keep failing on the new instances: "abort" the `+
+			`upgrade, "rollback" to also point the ASG back at its previous launch template versions and reattach the `+
+			`old instances not yet retired (new instances are not replaced), or "ignore"`)
+	upgradeClusterCmd.PersistentFlags().IntVar(&taskFailureThreshold, "task-failure-threshold",
+		ead.DefaultTaskFailureThreshold, "Number of failed tasks on the new instances that stops the upgrade")
+	upgradeClusterCmd.PersistentFlags().BoolVar(&dryRun, "dry-run",
+		false, "Show what the upgrade would do without making any changes")
+	upgradeClusterCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o",
+		outputTable, "Output format for --dry-run, table or json")
+}
+
+// upgradeFleet upgrades (or plans the upgrade of) the cluster in every region and exits
+func upgradeFleet(fleet *ead.Fleet) {
+	var clusters []string
+	if cluster != "" {
+		clusters = []string{cluster}
+	}
+
+	var results []ead.FleetResult
+	if dryRun {
+		results = fleet.PlanUpgrades(clusters)
+	} else {
+		results = fleet.UpgradeClusters(clusters)
+	}
+
+	failed := false
+	for _, r := range results {
+		if r.Err != nil {
+			failed = true
+		}
+	}
+
+	switch {
+	case dryRun && outputFormat == outputJSON:
+		type planResult struct {
+			Account string
+			Region  string
+			Plan    ead.UpgradePlan
+			Error   string
+		}
+		plans := make([]planResult, len(results))
+		for i, r := range results {
+			plans[i] = planResult{Account: r.Account, Region: r.Region, Plan: r.Plan}
+			if r.Err != nil {
+				plans[i].Error = r.Err.Error()
+			}
+		}
+		printJSON(plans)
+	default:
+		if dryRun {
+			for _, r := range results {
+				if r.Err == nil {
+					fmt.Printf("\nAccount %s, region %s\n\n", r.Account, r.Region)
+					printPlan(r.Plan)
+				}
+			}
+		}
+
+		fmt.Println("")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+		_, _ = fmt.Fprintln(w, "Account \t Region \t Cluster \t Result")
+		for _, r := range results {
+			result := "ok"
+			if r.Err != nil {
+				result = r.Err.Error()
+			}
+			_, _ = fmt.Fprintf(w, "%s \t %s \t %s \t %s\n", r.Account, r.Region, r.Cluster, result)
+		}
+		_ = w.Flush()
+		fmt.Println("")
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func printPlan(plan ead.UpgradePlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	_, _ = fmt.Fprintf(w, "Cluster:\t %s\n", plan.Cluster)
+	_, _ = fmt.Fprintf(w, "ASG:\t %s\n", plan.ASGName)
+	_, _ = fmt.Fprintf(w, "Launch template:\t %s (latest version %d)\n", plan.LaunchTemplateName, plan.LaunchTemplateVersion)
+	_, _ = fmt.Fprintf(w, "Upgrade needed:\t %t, %s\n", plan.UpgradeNeeded, plan.Reason)
+	_, _ = fmt.Fprintf(w, "Cluster instances:\t %s\n", strings.Join(plan.Instances, ", "))
+	if plan.BatchSize > 0 {
+		_, _ = fmt.Fprintf(w, "Batch size:\t %d\n", plan.BatchSize)
+	}
+	if len(plan.WarmPoolInstances) > 0 {
+		_, _ = fmt.Fprintf(w, "Warm pool instances:\t %s\n", strings.Join(plan.WarmPoolInstances, ", "))
+	}
+	for _, d := range plan.LaunchTemplateDeletions {
+		_, _ = fmt.Fprintf(w, "Delete launch template version:\t %s version %d, created %s\n",
+			d.LaunchTemplateName, d.VersionNumber, d.CreateTime.Format(time.RFC3339))
+	}
+	for _, f := range plan.PreflightFindings {
+		_, _ = fmt.Fprintf(w, "Preflight %s risk:\t %s: %s\n", f.Severity, f.Resource, f.Message)
+	}
+	for _, t := range plan.StandaloneTasks {
+		_, _ = fmt.Fprintf(w, "Standalone task (%s):\t %s: %s\n", plan.StandaloneTaskPolicy, t.Instance, t)
+	}
+	for _, c := range plan.BlockDeviceChanges {
+		_, _ = fmt.Fprintf(w, "Block device change:\t %s\n", c)
+	}
+	_ = w.Flush()
+
+	if plan.UserDataDiff != "" {
+		fmt.Printf("\nUser data changes:\n%s", plan.UserDataDiff)
+	}
+
+	printAMIDiff(plan.AMIDiff)
 }
@@ -9,42 +9,270 @@ import (
 )
 
 const (
-	DefaultAMIFilter           = "al2023-ami-ecs-hvm-*-x86_64"
-	DefaultPollingTimeout      = 15 * time.Minute
-	DefaultPollingInterval     = 5 * time.Second
-	DefaultLaunchTemplateLimit = 5
-	DefaultTimestampLayout     = "20060102T150405"
-	MinimumIntervalsForStable  = 6
-	TagNameASG                 = "ecs-ami-deploy-asg"
-	TagNameTerminate           = "ecs-ami-deploy-terminate"
-	Version                    = "0.0.0"
+	AllRegions                  = "all"
+	DefaultAMIFilter            = "al2023-ami-ecs-hvm-*-x86_64"
+	DefaultFleetConcurrency     = 3
+	DefaultPollingTimeout       = 15 * time.Minute
+	DefaultPollingInterval      = 5 * time.Second
+	DefaultRoleSessionName      = "ecs-ami-deploy"
+	DefaultLaunchTemplateLimit  = 5
+	DefaultLockTTL              = 10 * time.Minute
+	DefaultMaxRetryAttempts     = 10
+	DefaultMaxRetryBackoff      = 30 * time.Second
+	DefaultTaskFailureThreshold = 3
+	DefaultTimestampLayout      = "20060102T150405"
+	MinimumIntervalsForStable   = 6
+	TagNameASG                  = "ecs-ami-deploy-asg"
+	TagNameLock                 = "ecs-ami-deploy-lock"
+	TagNameSuspendedProcesses   = "ecs-ami-deploy-suspended-processes"
+	TagNameTerminate            = "ecs-ami-deploy-terminate"
+	Version                     = "0.0.0"
 )
 
+// DefaultVersionPolicy decides whether an upgrade makes the new launch template version the template's
+// default version
+type DefaultVersionPolicy string
+
+const (
+	// DefaultVersionPolicyAlways always makes the new version the default
+	DefaultVersionPolicyAlways DefaultVersionPolicy = "always"
+	// DefaultVersionPolicyAuto only changes the default if the ASG refers to the "$Default" version
+	DefaultVersionPolicyAuto DefaultVersionPolicy = "auto"
+	// DefaultVersionPolicyNever never changes the default, so ASGs that refer to "$Default" can't be upgraded
+	DefaultVersionPolicyNever DefaultVersionPolicy = "never"
+)
+
+// CapacityPolicy decides what happens when replacing the configured number of instances at a time would
+// exceed the vCPU quota, the free IP addresses in the ASG's subnets, or the ASG's max size
+type CapacityPolicy string
+
+const (
+	// CapacityPolicyFail stops the upgrade before any change is made
+	CapacityPolicyFail CapacityPolicy = "fail"
+	// CapacityPolicyAuto replaces instances in the largest batches that fit
+	CapacityPolicyAuto CapacityPolicy = "auto"
+)
+
+// FailurePolicy decides what happens when replacing instances fails before any old instance is deregistered
+type FailurePolicy string
+
+const (
+	// FailurePolicyRestore reattaches the old instances, terminates the new ones and restores the previous
+	// launch template versions
+	FailurePolicyRestore FailurePolicy = "restore"
+	// FailurePolicyNone leaves the old instances detached and tagged, so the next run terminates them
+	FailurePolicyNone FailurePolicy = "none"
+)
+
+// StandaloneTaskPolicy decides what happens to an old instance that runs tasks that don't belong to a service,
+// such as batch jobs and scheduled tasks, when it is removed
+type StandaloneTaskPolicy string
+
+const (
+	// StandaloneTaskPolicyProceed removes the instance, which stops its standalone tasks
+	StandaloneTaskPolicyProceed StandaloneTaskPolicy = "proceed"
+	// StandaloneTaskPolicyWait drains the instance and waits for its standalone tasks to finish
+	StandaloneTaskPolicyWait StandaloneTaskPolicy = "wait"
+	// StandaloneTaskPolicySkip drains the instance and leaves it for a later run while it has standalone tasks
+	StandaloneTaskPolicySkip StandaloneTaskPolicy = "skip"
+)
+
+// TaskFailurePolicy decides what happens when tasks keep failing on the new container instances
+type TaskFailurePolicy string
+
+const (
+	// TaskFailurePolicyAbort stops the upgrade, which is undone according to the FailurePolicy if no old
+	// instance has been deregistered yet
+	TaskFailurePolicyAbort TaskFailurePolicy = "abort"
+	// TaskFailurePolicyRollback stops the upgrade and also points the ASG back at its previous launch template
+	// versions if old instances have already been deregistered. Old instances that weren't retired yet are
+	// reattached if they fit under the ASG's max size, but new instances already running the new AMI are not
+	// replaced.
+	TaskFailurePolicyRollback TaskFailurePolicy = "rollback"
+	// TaskFailurePolicyIgnore doesn't watch for failed tasks
+	TaskFailurePolicyIgnore TaskFailurePolicy = "ignore"
+)
+
+var DefaultAMIOwners = []string{"amazon"}
+
 type ClusterMeta struct {
+	Account string
 	Cluster ecsTypes.Cluster
 	Image   ec2types.Image
+	Region  string
+}
+
+// AMIMeta is an image along with the names of the clusters whose launch templates use it
+type AMIMeta struct {
+	Image    ec2types.Image
+	Clusters []string
 }
 
 type Config struct {
-	AMIFilter                string
-	Cluster                  string
-	ForceReplacement         bool
+	// AbortOnHighRisk refuses to upgrade a cluster if the preflight checks find high risk services or tasks
+	AbortOnHighRisk bool
+	AMIFilter       string
+	AMIOwners       []string
+	// BatchSize is the number of instances replaced at a time. Zero replaces all of them at once.
+	BatchSize int
+	// CallLimiter limits the rate of AWS API calls. Upgraders that share a limiter share its budget. If nil
+	// and CallsPerSecond is set, a limiter is created.
+	CallLimiter *CallLimiter
+	// CallsPerSecond is the budget for AWS API calls. Zero doesn't limit calls.
+	CallsPerSecond       float64
+	CapacityPolicy       CapacityPolicy
+	Cluster              string
+	DefaultVersionPolicy DefaultVersionPolicy
+	// DeploymentInterval and DeploymentTimeout apply to waiting for service deployments to complete
+	DeploymentInterval time.Duration
+	DeploymentTimeout  time.Duration
+	FailurePolicy      FailurePolicy
+	ForceReplacement   bool
+	// InServiceInterval and InServiceTimeout apply to waiting for new instances to be in service with the ASG.
+	// Unlike the other intervals, zero keeps the waiter's backoff of 15 to 120 seconds.
+	InServiceInterval time.Duration
+	InServiceTimeout  time.Duration
+	// InstanceType sets the instance type in the new launch template version
+	InstanceType string
+	// InstanceTypeOverrides replaces the instance types in the ASG's mixed instances policy
+	InstanceTypeOverrides []string
+	// KeepInstancesInASG replaces instances by scaling the ASG out instead of detaching the old instances, which
+	// are then terminated through the ASG so that its termination lifecycle hooks run
+	KeepInstancesInASG       bool
 	LaunchTemplateLimit      int
 	LaunchTemplateNamePrefix string
-	Logger                   *log.Logger
-	PollingInterval          time.Duration
-	PollingTimeout           time.Duration
-	TimestampLayout          string
+	// LaunchTemplateMutators are run in order on the data for each new launch template version
+	LaunchTemplateMutators []LaunchTemplateMutator
+	// LaunchTemplateRetentionAge keeps launch template versions younger than this even when the template
+	// has more than LaunchTemplateLimit versions. Zero disables age-based retention.
+	LaunchTemplateRetentionAge time.Duration
+	// LifecycleHookTimeout is how long to wait for an instance terminated through its ASG to complete its
+	// termination lifecycle hooks. It defaults to PollingTimeout.
+	LifecycleHookTimeout time.Duration
+	// LockOwner identifies who holds the upgrade lock. It defaults to the user and host name.
+	LockOwner string
+	// LockTTL is how long the upgrade lock lasts without being refreshed
+	LockTTL time.Duration
+	Logger  *log.Logger
+	// MaxRetryAttempts and MaxRetryBackoff limit the retries of throttled or failed AWS API calls, which back
+	// off exponentially with jitter
+	MaxRetryAttempts int
+	MaxRetryBackoff  time.Duration
+	// MinimumAgentVersion is the oldest ECS agent version that new container instances may run
+	MinimumAgentVersion string
+	// MinimumIntervalsForStable is the number of stability checks in a row that must find no pending tasks
+	// before the cluster is considered stable
+	MinimumIntervalsForStable int
+	// MinimumRegisteredCPU and MinimumRegisteredMemory (in MiB) are the least CPU and memory new container
+	// instances must register with the cluster
+	MinimumRegisteredCPU    int32
+	MinimumRegisteredMemory int32
+	// PollingInterval and PollingTimeout are used for each phase that has no interval or timeout of its own
+	PollingInterval time.Duration
+	PollingTimeout  time.Duration
+	// RegistrationInterval and RegistrationTimeout apply to waiting for new instances to register with the
+	// cluster
+	RegistrationInterval time.Duration
+	RegistrationTimeout  time.Duration
+	// RequiredAttributes are the attributes new container instances must have, given as a name or name=value
+	RequiredAttributes []string
+	// ResourceFitTimeout is how long to wait for the tasks on an old instance to fit on the rest of the
+	// cluster before it is deregistered. It defaults to PollingTimeout.
+	ResourceFitTimeout time.Duration
+	// RunID identifies the run in launch template version descriptions and the upgrade lock. A random ID is
+	// generated if empty.
+	RunID string
+	// SkipAMIUpgrade keeps the current AMI, so that only the instance type is changed
+	SkipAMIUpgrade bool
+	// StabilityInterval and StabilityTimeout apply to waiting for the cluster to have no pending tasks
+	StabilityInterval time.Duration
+	StabilityTimeout  time.Duration
+	// StandaloneTaskPolicy decides what happens to old instances with tasks that don't belong to a service
+	StandaloneTaskPolicy StandaloneTaskPolicy
+	// StandaloneTaskTimeout is how long the wait policy waits for standalone tasks to finish. It defaults to
+	// PollingTimeout.
+	StandaloneTaskTimeout time.Duration
+	// SuspendReplaceUnhealthy also suspends the ASG's ReplaceUnhealthy process during the upgrade
+	SuspendReplaceUnhealthy bool
+	// TaskFailurePolicy decides what happens once TaskFailureThreshold tasks have failed on the new container
+	// instances during the upgrade
+	TaskFailurePolicy    TaskFailurePolicy
+	TaskFailureThreshold int
+	TimestampLayout      string
+	// ToolVersion is recorded in launch template version descriptions
+	ToolVersion string
+	// WarmPoolInterval and WarmPoolTimeout apply to waiting for the ASG's warm pool to be deleted
+	WarmPoolInterval time.Duration
+	WarmPoolTimeout  time.Duration
 }
 
 var DefaultConfig = Config{
-	AMIFilter:                DefaultAMIFilter,
-	Cluster:                  "",
-	ForceReplacement:         false,
-	LaunchTemplateLimit:      DefaultLaunchTemplateLimit,
-	LaunchTemplateNamePrefix: "",
-	Logger:                   nil,
-	PollingInterval:          DefaultPollingInterval,
-	PollingTimeout:           DefaultPollingTimeout,
-	TimestampLayout:          DefaultTimestampLayout,
+	AbortOnHighRisk:            false,
+	AMIFilter:                  DefaultAMIFilter,
+	AMIOwners:                  DefaultAMIOwners,
+	BatchSize:                  0,
+	CallLimiter:                nil,
+	CallsPerSecond:             0,
+	CapacityPolicy:             CapacityPolicyFail,
+	Cluster:                    "",
+	DefaultVersionPolicy:       DefaultVersionPolicyAlways,
+	DeploymentInterval:         0,
+	DeploymentTimeout:          0,
+	FailurePolicy:              FailurePolicyRestore,
+	ForceReplacement:           false,
+	InServiceInterval:          0,
+	InServiceTimeout:           0,
+	InstanceType:               "",
+	InstanceTypeOverrides:      nil,
+	KeepInstancesInASG:         false,
+	LaunchTemplateLimit:        DefaultLaunchTemplateLimit,
+	LaunchTemplateMutators:     nil,
+	LaunchTemplateNamePrefix:   "",
+	LaunchTemplateRetentionAge: 0,
+	LifecycleHookTimeout:       0,
+	LockOwner:                  "",
+	LockTTL:                    DefaultLockTTL,
+	Logger:                     nil,
+	MaxRetryAttempts:           DefaultMaxRetryAttempts,
+	MaxRetryBackoff:            DefaultMaxRetryBackoff,
+	MinimumAgentVersion:        "",
+	MinimumIntervalsForStable:  MinimumIntervalsForStable,
+	MinimumRegisteredCPU:       0,
+	MinimumRegisteredMemory:    0,
+	PollingInterval:            DefaultPollingInterval,
+	PollingTimeout:             DefaultPollingTimeout,
+	RegistrationInterval:       0,
+	RegistrationTimeout:        0,
+	RequiredAttributes:         nil,
+	ResourceFitTimeout:         0,
+	RunID:                      "",
+	SkipAMIUpgrade:             false,
+	StabilityInterval:          0,
+	StabilityTimeout:           0,
+	StandaloneTaskPolicy:       StandaloneTaskPolicyProceed,
+	StandaloneTaskTimeout:      0,
+	SuspendReplaceUnhealthy:    false,
+	TaskFailurePolicy:          TaskFailurePolicyAbort,
+	TaskFailureThreshold:       DefaultTaskFailureThreshold,
+	TimestampLayout:            DefaultTimestampLayout,
+	ToolVersion:                Version,
+	WarmPoolInterval:           0,
+	WarmPoolTimeout:            0,
+}
+
+// FleetConfig controls which accounts and regions a Fleet operates in and how many run at once. Regions
+// applies to the default credentials and to any target that doesn't list its own regions.
+type FleetConfig struct {
+	Concurrency int
+	Regions     []string
+	Targets     []Target
+}
+
+// Target is a set of regions and clusters in an AWS account that is reached by assuming RoleARN. If
+// RoleARN is empty, the default credentials are used.
+type Target struct {
+	RoleARN    string
+	ExternalID string
+	Regions    []string
+	Clusters   []string
 }
@@ -0,0 +1,363 @@
+package ead
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Fleet runs Upgrader operations in several accounts and regions in parallel. Each account and region
+// gets its own Upgrader, so the latest AMI is resolved independently in every region.
+type Fleet struct {
+	config      Config
+	targets     []fleetTarget
+	concurrency int
+}
+
+// fleetTarget is a Target with its credentials loaded and its regions resolved
+type fleetTarget struct {
+	account  string
+	awsCfg   aws.Config
+	regions  []string
+	clusters []string
+}
+
+// FleetResult is the outcome of an operation on one cluster in one account and region
+type FleetResult struct {
+	Account string
+	Region  string
+	Cluster string
+	Plan    UpgradePlan
+	Err     error
+}
+
+func NewFleet(awsCfg aws.Config, config *Config, fleetConfig *FleetConfig) (*Fleet, error) {
+	if awsCfg.Region == "" {
+		return nil, fmt.Errorf("awsCfg must be initialized before use")
+	}
+
+	if config == nil {
+		config = &DefaultConfig
+	}
+	if fleetConfig == nil {
+		fleetConfig = &FleetConfig{}
+	}
+
+	fleet := &Fleet{
+		config:      *config,
+		concurrency: fleetConfig.Concurrency,
+	}
+
+	if fleet.config.Logger == nil {
+		fleet.config.Logger = log.Default()
+		fleet.config.Logger.SetOutput(os.Stdout)
+	}
+	// every cluster upgraded by the fleet shares the same run ID
+	if fleet.config.RunID == "" {
+		fleet.config.RunID = newRunID()
+	}
+	// the upgraders in every region and account share one API call budget
+	if fleet.config.CallLimiter == nil && fleet.config.CallsPerSecond > 0 {
+		fleet.config.CallLimiter = NewCallLimiter(fleet.config.CallsPerSecond)
+	}
+	if fleet.concurrency <= 0 {
+		fleet.concurrency = DefaultFleetConcurrency
+	}
+
+	targets := fleetConfig.Targets
+	if len(targets) == 0 {
+		targets = []Target{{}}
+	}
+
+	for _, t := range targets {
+		if len(t.Regions) == 0 {
+			t.Regions = fleetConfig.Regions
+		}
+		ft, err := loadFleetTarget(awsCfg, t)
+		if err != nil {
+			return nil, err
+		}
+		fleet.targets = append(fleet.targets, ft)
+	}
+
+	return fleet, nil
+}
+
+// loadFleetTarget assumes the target's role, if it has one, and looks up the account ID and regions
+// using the resulting credentials. Assumed role credentials are cached and refreshed before they expire,
+// so they remain valid through long upgrades.
+func loadFleetTarget(awsCfg aws.Config, target Target) (fleetTarget, error) {
+	ft := fleetTarget{
+		awsCfg:   awsCfg.Copy(),
+		clusters: target.Clusters,
+	}
+
+	if target.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), target.RoleARN,
+			func(options *stscreds.AssumeRoleOptions) {
+				options.RoleSessionName = DefaultRoleSessionName
+				if target.ExternalID != "" {
+					options.ExternalID = aws.String(target.ExternalID)
+				}
+			})
+		ft.awsCfg.Credentials = aws.NewCredentialsCache(provider, func(options *aws.CredentialsCacheOptions) {
+			options.ExpiryWindow = 5 * time.Minute
+		})
+	}
+
+	identity, err := sts.NewFromConfig(ft.awsCfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		if target.RoleARN != "" {
+			return fleetTarget{}, fmt.Errorf("failed to assume role %s: %s", target.RoleARN, err)
+		}
+		return fleetTarget{}, fmt.Errorf("failed to get caller identity: %s", err)
+	}
+	ft.account = *identity.Account
+
+	ft.regions = target.Regions
+	if len(ft.regions) == 0 {
+		ft.regions = []string{awsCfg.Region}
+	}
+	for _, r := range ft.regions {
+		if r == AllRegions {
+			enabled, err := EnabledRegions(ft.awsCfg)
+			if err != nil {
+				return fleetTarget{}, fmt.Errorf("account %s: %w", ft.account, err)
+			}
+			ft.regions = enabled
+			break
+		}
+	}
+
+	return ft, nil
+}
+
+// EnabledRegions returns the names of all regions enabled for the account
+func EnabledRegions(awsCfg aws.Config) ([]string, error) {
+	result, err := ec2.NewFromConfig(awsCfg).DescribeRegions(context.Background(), &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe regions: %s", err)
+	}
+
+	regions := make([]string, len(result.Regions))
+	for i, r := range result.Regions {
+		regions[i] = *r.RegionName
+	}
+	sort.Strings(regions)
+
+	return regions, nil
+}
+
+// Accounts returns the IDs of the accounts the fleet operates in
+func (f *Fleet) Accounts() []string {
+	accounts := make([]string, len(f.targets))
+	for i, t := range f.targets {
+		accounts[i] = t.account
+	}
+	return accounts
+}
+
+// ListClusters lists the clusters in every account and region, limited to the target's clusters if it
+// lists any. Clusters from regions that could not be listed are omitted and the region errors are returned
+// together.
+func (f *Fleet) ListClusters() ([]ClusterMeta, error) {
+	var mu sync.Mutex
+	var allClusters []ClusterMeta
+	var errs []error
+
+	f.forEachTargetRegion(func(t fleetTarget, region string) {
+		var clusters []ClusterMeta
+		upgrader, err := f.newUpgrader(t, region, "")
+		if err == nil {
+			clusters, err = upgrader.ListClusters()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("account %s region %s: %w", t.account, region, err))
+			return
+		}
+		for _, c := range clusters {
+			if len(t.clusters) > 0 && !internal.IsStringInSlice(*c.Cluster.ClusterName, t.clusters) {
+				continue
+			}
+			c.Account = t.account
+			allClusters = append(allClusters, c)
+		}
+	})
+
+	sort.SliceStable(allClusters, func(i, j int) bool {
+		if allClusters[i].Account != allClusters[j].Account {
+			return allClusters[i].Account < allClusters[j].Account
+		}
+		if allClusters[i].Region != allClusters[j].Region {
+			return allClusters[i].Region < allClusters[j].Region
+		}
+		return *allClusters[i].Cluster.ClusterName < *allClusters[j].Cluster.ClusterName
+	})
+
+	return allClusters, errors.Join(errs...)
+}
+
+// LatestAMIs resolves the latest AMI for the configured filter in every account and region, keyed by
+// account and then by region
+func (f *Fleet) LatestAMIs() (map[string]map[string]ec2types.Image, error) {
+	var mu sync.Mutex
+	images := make(map[string]map[string]ec2types.Image, len(f.targets))
+	var errs []error
+
+	f.forEachTargetRegion(func(t fleetTarget, region string) {
+		var img ec2types.Image
+		upgrader, err := f.newUpgrader(t, region, "")
+		if err == nil {
+			img, err = upgrader.LatestAMI()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("account %s region %s: %w", t.account, region, err))
+			return
+		}
+		if images[t.account] == nil {
+			images[t.account] = map[string]ec2types.Image{}
+		}
+		images[t.account][region] = img
+	})
+
+	return images, errors.Join(errs...)
+}
+
+// UpgradeClusters upgrades clusters in every account and region. The clusters given here restrict a target's
+// own list of clusters, and targets that list none of them are skipped. Regions run in parallel while the
+// clusters within a region are upgraded one at a time.
+func (f *Fleet) UpgradeClusters(clusters []string) []FleetResult {
+	return f.forEachCluster(clusters, func(upgrader *Upgrader, result *FleetResult) {
+		result.Err = upgrader.UpgradeCluster()
+	})
+}
+
+// PlanUpgrades reports what UpgradeClusters would do, without changing anything
+func (f *Fleet) PlanUpgrades(clusters []string) []FleetResult {
+	return f.forEachCluster(clusters, func(upgrader *Upgrader, result *FleetResult) {
+		result.Plan, result.Err = upgrader.PlanUpgrade()
+	})
+}
+
+func (f *Fleet) forEachCluster(clusters []string, fn func(upgrader *Upgrader, result *FleetResult)) []FleetResult {
+	var mu sync.Mutex
+	var results []FleetResult
+
+	f.forEachTargetRegion(func(t fleetTarget, region string) {
+		targetClusters := clustersForTarget(clusters, t.clusters)
+		if len(targetClusters) == 0 && len(clusters) > 0 {
+			// none of the given clusters are in this target
+			return
+		}
+		if len(targetClusters) == 0 {
+			mu.Lock()
+			results = append(results, FleetResult{
+				Account: t.account,
+				Region:  region,
+				Err:     fmt.Errorf("no clusters given for account %s", t.account),
+			})
+			mu.Unlock()
+			return
+		}
+
+		for _, cluster := range targetClusters {
+			result := FleetResult{Account: t.account, Region: region, Cluster: cluster}
+
+			upgrader, err := f.newUpgrader(t, region, cluster)
+			if err != nil {
+				result.Err = err
+			} else {
+				fn(upgrader, &result)
+			}
+
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Account != results[j].Account {
+			return results[i].Account < results[j].Account
+		}
+		if results[i].Region != results[j].Region {
+			return results[i].Region < results[j].Region
+		}
+		return results[i].Cluster < results[j].Cluster
+	})
+
+	return results
+}
+
+// clustersForTarget returns the clusters to run in for a target. The given clusters restrict the target's own
+// list of clusters, and are used as they are for targets without a list.
+func clustersForTarget(clusters, targetClusters []string) []string {
+	if len(targetClusters) == 0 {
+		return clusters
+	}
+	if len(clusters) == 0 {
+		return targetClusters
+	}
+	var both []string
+	for _, c := range targetClusters {
+		if internal.IsStringInSlice(c, clusters) {
+			both = append(both, c)
+		}
+	}
+	return both
+}
+
+// forEachTargetRegion calls fn for every region of every target, running at most f.concurrency at a time
+func (f *Fleet) forEachTargetRegion(fn func(t fleetTarget, region string)) {
+	sem := make(chan struct{}, f.concurrency)
+	var wg sync.WaitGroup
+
+	for _, t := range f.targets {
+		for _, region := range t.regions {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(t fleetTarget, region string) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				fn(t, region)
+			}(t, region)
+		}
+	}
+
+	wg.Wait()
+}
+
+// newUpgrader returns an Upgrader for the target's account in the given region. Its logger labels output
+// with the account and region.
+func (f *Fleet) newUpgrader(t fleetTarget, region, cluster string) (*Upgrader, error) {
+	awsCfg := t.awsCfg.Copy()
+	awsCfg.Region = region
+
+	config := f.config
+	config.Cluster = cluster
+	config.Logger = log.New(f.config.Logger.Writer(), fmt.Sprintf("[%s %s] ", t.account, region), f.config.Logger.Flags())
+
+	return NewUpgrader(awsCfg, &config)
+}
@@ -0,0 +1,70 @@
+package ead
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFleet_forEachTargetRegion(t *testing.T) {
+	regions := []string{"us-east-1", "us-east-2", "us-west-2", "eu-west-1", "ap-southeast-1"}
+	fleet := &Fleet{
+		targets: []fleetTarget{
+			{account: "111111111111", regions: regions[:2]},
+			{account: "222222222222", regions: regions[2:]},
+		},
+		concurrency: 2,
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	visited := map[string]bool{}
+
+	fleet.forEachTargetRegion(func(target fleetTarget, region string) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		visited[target.account+"/"+region] = true
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	if len(visited) != len(regions) {
+		t.Errorf("visited %d regions, want %d", len(visited), len(regions))
+	}
+	if maxRunning > 2 {
+		t.Errorf("ran %d regions at once, want at most 2", maxRunning)
+	}
+}
+
+func TestClustersForTarget(t *testing.T) {
+	tests := []struct {
+		name           string
+		clusters       []string
+		targetClusters []string
+		want           []string
+	}{
+		{name: "target list only", targetClusters: []string{"prod", "staging"}, want: []string{"prod", "staging"}},
+		{name: "given clusters only", clusters: []string{"prod"}, want: []string{"prod"}},
+		{name: "given clusters restrict target", clusters: []string{"staging"}, targetClusters: []string{"prod", "staging"},
+			want: []string{"staging"}},
+		{name: "target without given clusters", clusters: []string{"dev"}, targetClusters: []string{"prod"}, want: nil},
+		{name: "neither", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clustersForTarget(tt.clusters, tt.targetClusters)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
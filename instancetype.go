@@ -0,0 +1,174 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+)
+
+// changesInstanceTypes is true if the config sets a new instance type or new mixed instances policy overrides
+func (u *Upgrader) changesInstanceTypes() bool {
+	return u.instanceType != "" || len(u.instanceTypeOverrides) > 0
+}
+
+// configuredInstanceTypes returns the instance types the cluster's instances should have once upgraded: the
+// instance type overrides if set, otherwise the instance type. It is empty if neither is set.
+func (u *Upgrader) configuredInstanceTypes() []string {
+	if len(u.instanceTypeOverrides) > 0 {
+		return u.instanceTypeOverrides
+	}
+	if u.instanceType != "" {
+		return []string{u.instanceType}
+	}
+	return nil
+}
+
+// outdatedInstanceReason explains why the instance needs to be replaced, or returns an empty string if it runs
+// the latest image and, if instanceTypes isn't empty, one of those instance types
+func outdatedInstanceReason(instance ec2types.Instance, latestImageId string, instanceTypes []string) string {
+	if aws.ToString(instance.ImageId) != latestImageId {
+		return fmt.Sprintf("it runs an older image (%s)", aws.ToString(instance.ImageId))
+	}
+	if len(instanceTypes) > 0 && !internal.IsStringInSlice(string(instance.InstanceType), instanceTypes) {
+		return fmt.Sprintf("its instance type %s is not %s", instance.InstanceType, strings.Join(instanceTypes, " or "))
+	}
+	return ""
+}
+
+// checkInstanceTypeForPolicy returns an error if an instance type is configured for an ASG whose instance types
+// come from the overrides of its mixed instances policy. The instance type would only be written into the launch
+// template, while the ASG keeps launching the override instance types.
+func (u *Upgrader) checkInstanceTypeForPolicy(asg *asgTypes.AutoScalingGroup) error {
+	if u.instanceType == "" || asg.MixedInstancesPolicy == nil || asg.MixedInstancesPolicy.LaunchTemplate == nil {
+		return nil
+	}
+	if len(asg.MixedInstancesPolicy.LaunchTemplate.Overrides) == 0 && len(u.instanceTypeOverrides) == 0 {
+		return nil
+	}
+	return fmt.Errorf("ASG %s launches the instance types of its mixed instances policy overrides, set those "+
+		"with instance type overrides instead of an instance type", aws.ToString(asg.AutoScalingGroupName))
+}
+
+// checkInstanceTypeArchitecture returns an error if any of the configured instance types doesn't support
+// the image's architecture
+func (u *Upgrader) checkInstanceTypeArchitecture(image ec2types.Image) error {
+	var types []ec2types.InstanceType
+	if u.instanceType != "" {
+		types = append(types, ec2types.InstanceType(u.instanceType))
+	}
+	for _, t := range u.instanceTypeOverrides {
+		types = append(types, ec2types.InstanceType(t))
+	}
+	if len(types) == 0 {
+		return nil
+	}
+
+	result, err := u.ec2Client.DescribeInstanceTypes(context.Background(), &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: types,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe instance types: %w", err)
+	}
+
+	supported := map[ec2types.InstanceType][]ec2types.ArchitectureType{}
+	for _, info := range result.InstanceTypes {
+		if info.ProcessorInfo != nil {
+			supported[info.InstanceType] = info.ProcessorInfo.SupportedArchitectures
+		}
+	}
+
+	for _, t := range types {
+		architectures, ok := supported[t]
+		if !ok {
+			return fmt.Errorf("instance type %s not found", t)
+		}
+		if !slices.Contains(architectures, ec2types.ArchitectureType(image.Architecture)) {
+			return fmt.Errorf("instance type %s does not support the %s architecture of image %s",
+				t, image.Architecture, aws.ToString(image.ImageId))
+		}
+	}
+
+	return nil
+}
+
+// instanceTypeChanges describes how the configured instance types differ from those currently used by
+// the ASG and its launch template
+func (u *Upgrader) instanceTypeChanges(asg *asgTypes.AutoScalingGroup, ltData *ec2types.ResponseLaunchTemplateData) []string {
+	var changes []string
+	if u.instanceType != "" && string(ltData.InstanceType) != u.instanceType {
+		changes = append(changes, fmt.Sprintf("instance type changes from %s to %s", ltData.InstanceType, u.instanceType))
+	}
+
+	if len(u.instanceTypeOverrides) > 0 && asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		current := overrideInstanceTypes(asg.MixedInstancesPolicy)
+		if !slices.Equal(current, u.instanceTypeOverrides) {
+			changes = append(changes, fmt.Sprintf("instance type overrides change from %s to %s",
+				strings.Join(current, ", "), strings.Join(u.instanceTypeOverrides, ", ")))
+		}
+	}
+
+	return changes
+}
+
+// applyInstanceTypeOverrides replaces the overrides in the ASG's mixed instances policy with the configured
+// instance types. The ASG itself is not updated.
+func (u *Upgrader) applyInstanceTypeOverrides(asg *asgTypes.AutoScalingGroup) error {
+	if len(u.instanceTypeOverrides) == 0 {
+		return nil
+	}
+	if asg.MixedInstancesPolicy == nil || asg.MixedInstancesPolicy.LaunchTemplate == nil {
+		return fmt.Errorf("instance type overrides require ASG %s to have a mixed instances policy",
+			aws.ToString(asg.AutoScalingGroupName))
+	}
+
+	asg.MixedInstancesPolicy = mixedInstancesPolicyWithInstanceTypes(asg.MixedInstancesPolicy, u.instanceTypeOverrides)
+	return nil
+}
+
+// overrideInstanceTypes returns the instance types of the overrides in a mixed instances policy, in order
+func overrideInstanceTypes(policy *asgTypes.MixedInstancesPolicy) []string {
+	var types []string
+	for _, o := range policy.LaunchTemplate.Overrides {
+		if o.InstanceType != nil {
+			types = append(types, *o.InstanceType)
+		}
+	}
+	return types
+}
+
+// mixedInstancesPolicyWithInstanceTypes returns a copy of the policy with one override for each instance
+// type, in the given order. Existing overrides for those instance types are kept, with their weights and
+// launch templates, while overrides for other instance types are removed.
+func mixedInstancesPolicyWithInstanceTypes(policy *asgTypes.MixedInstancesPolicy,
+	types []string) *asgTypes.MixedInstancesPolicy {
+
+	existing := map[string]asgTypes.LaunchTemplateOverrides{}
+	for _, o := range policy.LaunchTemplate.Overrides {
+		if o.InstanceType != nil {
+			existing[*o.InstanceType] = o
+		}
+	}
+
+	ltPolicy := *policy.LaunchTemplate
+	ltPolicy.Overrides = make([]asgTypes.LaunchTemplateOverrides, len(types))
+	for i, t := range types {
+		o, ok := existing[t]
+		if !ok {
+			o = asgTypes.LaunchTemplateOverrides{InstanceType: aws.String(t)}
+		}
+		ltPolicy.Overrides[i] = o
+	}
+
+	return &asgTypes.MixedInstancesPolicy{
+		InstancesDistribution: policy.InstancesDistribution,
+		LaunchTemplate:        &ltPolicy,
+	}
+}
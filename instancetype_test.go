@@ -0,0 +1,144 @@
+package ead
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestMixedInstancesPolicyWithInstanceTypes(t *testing.T) {
+	policy := &asgTypes.MixedInstancesPolicy{
+		InstancesDistribution: &asgTypes.InstancesDistribution{OnDemandBaseCapacity: aws.Int32(1)},
+		LaunchTemplate: &asgTypes.LaunchTemplate{
+			LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-main")},
+			Overrides: []asgTypes.LaunchTemplateOverrides{
+				{InstanceType: aws.String("m5.large"), WeightedCapacity: aws.String("1")},
+				{InstanceType: aws.String("m7g.large"), WeightedCapacity: aws.String("2")},
+			},
+		},
+	}
+
+	got := mixedInstancesPolicyWithInstanceTypes(policy, []string{"m7g.large", "m7g.xlarge"})
+
+	if types := overrideInstanceTypes(got); !slices.Equal(types, []string{"m7g.large", "m7g.xlarge"}) {
+		t.Fatalf("override instance types are %v", types)
+	}
+	if w := aws.ToString(got.LaunchTemplate.Overrides[0].WeightedCapacity); w != "2" {
+		t.Errorf("existing override weight is %q, want 2", w)
+	}
+	if got.LaunchTemplate.Overrides[1].WeightedCapacity != nil {
+		t.Error("new override should have no weight")
+	}
+	if got.InstancesDistribution != policy.InstancesDistribution ||
+		got.LaunchTemplate.LaunchTemplateSpecification != policy.LaunchTemplate.LaunchTemplateSpecification {
+		t.Error("launch template and instances distribution were not preserved")
+	}
+	if len(policy.LaunchTemplate.Overrides) != 2 || *policy.LaunchTemplate.Overrides[0].InstanceType != "m5.large" {
+		t.Error("original policy was modified")
+	}
+}
+
+func TestUpgrader_instanceTypeChanges(t *testing.T) {
+	asg := &asgTypes.AutoScalingGroup{
+		MixedInstancesPolicy: &asgTypes.MixedInstancesPolicy{
+			LaunchTemplate: &asgTypes.LaunchTemplate{
+				Overrides: []asgTypes.LaunchTemplateOverrides{{InstanceType: aws.String("m5.large")}},
+			},
+		},
+	}
+	ltData := &ec2types.ResponseLaunchTemplateData{InstanceType: ec2types.InstanceTypeM5Large}
+
+	tests := []struct {
+		name      string
+		upgrader  Upgrader
+		wantCount int
+	}{
+		{name: "none configured", upgrader: Upgrader{}, wantCount: 0},
+		{name: "same instance type", upgrader: Upgrader{instanceType: "m5.large"}, wantCount: 0},
+		{name: "new instance type", upgrader: Upgrader{instanceType: "m7g.large"}, wantCount: 1},
+		{name: "same overrides", upgrader: Upgrader{instanceTypeOverrides: []string{"m5.large"}}, wantCount: 0},
+		{
+			name:      "new type and overrides",
+			upgrader:  Upgrader{instanceType: "m7g.large", instanceTypeOverrides: []string{"m7g.large", "m7g.xlarge"}},
+			wantCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.upgrader.instanceTypeChanges(asg, ltData); len(got) != tt.wantCount {
+				t.Errorf("instanceTypeChanges() = %q, want %d changes", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestUpgrader_checkInstanceTypeForPolicy(t *testing.T) {
+	single := &asgTypes.AutoScalingGroup{AutoScalingGroupName: aws.String("ecs-prod")}
+	mixed := &asgTypes.AutoScalingGroup{
+		AutoScalingGroupName: aws.String("ecs-prod"),
+		MixedInstancesPolicy: &asgTypes.MixedInstancesPolicy{
+			LaunchTemplate: &asgTypes.LaunchTemplate{
+				Overrides: []asgTypes.LaunchTemplateOverrides{{InstanceType: aws.String("m5.large")}},
+			},
+		},
+	}
+	noOverrides := &asgTypes.AutoScalingGroup{
+		AutoScalingGroupName: aws.String("ecs-prod"),
+		MixedInstancesPolicy: &asgTypes.MixedInstancesPolicy{LaunchTemplate: &asgTypes.LaunchTemplate{}},
+	}
+
+	tests := []struct {
+		name     string
+		upgrader Upgrader
+		asg      *asgTypes.AutoScalingGroup
+		wantErr  bool
+	}{
+		{name: "no mixed instances policy", upgrader: Upgrader{instanceType: "m7g.large"}, asg: single},
+		{name: "mixed policy with overrides", upgrader: Upgrader{instanceType: "m7g.large"}, asg: mixed, wantErr: true},
+		{name: "mixed policy overrides only", upgrader: Upgrader{instanceTypeOverrides: []string{"m7g.large"}}, asg: mixed},
+		{name: "mixed policy without overrides", upgrader: Upgrader{instanceType: "m7g.large"}, asg: noOverrides},
+		{
+			name:     "mixed policy given overrides",
+			upgrader: Upgrader{instanceType: "m7g.large", instanceTypeOverrides: []string{"m7g.large"}},
+			asg:      noOverrides,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.upgrader.checkInstanceTypeForPolicy(tt.asg); (err != nil) != tt.wantErr {
+				t.Errorf("checkInstanceTypeForPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOutdatedInstanceReason(t *testing.T) {
+	instance := ec2types.Instance{
+		InstanceId:   aws.String("i-1"),
+		ImageId:      aws.String("ami-new"),
+		InstanceType: ec2types.InstanceTypeM5Large,
+	}
+	tests := []struct {
+		name          string
+		latestImage   string
+		instanceTypes []string
+		want          string
+	}{
+		{name: "current", latestImage: "ami-new", want: ""},
+		{name: "older image", latestImage: "ami-newer", want: "it runs an older image (ami-new)"},
+		{name: "configured type", latestImage: "ami-new", instanceTypes: []string{"m6i.large", "m5.large"}, want: ""},
+		{name: "old instance type", latestImage: "ami-new", instanceTypes: []string{"m6i.large"},
+			want: "its instance type m5.large is not m6i.large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outdatedInstanceReason(instance, tt.latestImage, tt.instanceTypes); got != tt.want {
+				t.Errorf("outdatedInstanceReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// MigrateLaunchConfiguration converts the launch configuration used by the cluster's ASG into a new launch
+// template and points the ASG at it. The launch template is named with the launch template name prefix
+// and a timestamp. Afterwards, UpgradeCluster can be used on the cluster.
+func (u *Upgrader) MigrateLaunchConfiguration() (*ec2types.LaunchTemplate, error) {
+	if u.cluster == "" {
+		return nil, fmt.Errorf("cluster name must be set in config to migrate a launch configuration")
+	}
+
+	asgName, err := u.getAsgNameForCluster(u.cluster)
+	if err != nil {
+		return nil, err
+	}
+	u.logger.Printf("Found ASG: %s\n", asgName)
+
+	asg, err := u.getAsgByName(asgName)
+	if err != nil {
+		return nil, err
+	}
+	if asg.LaunchConfigurationName == nil {
+		return nil, fmt.Errorf("ASG %s does not use a launch configuration", asgName)
+	}
+
+	result, err := u.asgClient.DescribeLaunchConfigurations(context.Background(), &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []string{*asg.LaunchConfigurationName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe launch configuration: %w", err)
+	}
+	if len(result.LaunchConfigurations) == 0 {
+		return nil, fmt.Errorf("launch configuration %s not found", *asg.LaunchConfigurationName)
+	}
+	lc := result.LaunchConfigurations[0]
+	u.logger.Printf("Launch configuration: %s\n", *lc.LaunchConfigurationName)
+
+	name := u.launchTemplateNamePrefix + "-" + internal.CurrentTimestamp(u.timestampLayout)
+	out, err := u.ec2Client.CreateLaunchTemplate(context.Background(), &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(name),
+		LaunchTemplateData: launchTemplateDataFromLaunchConfiguration(lc),
+		VersionDescription: aws.String("Migrated from launch configuration " + *lc.LaunchConfigurationName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create launch template: %w", err)
+	}
+	u.logger.Printf("Launch template %s created\n", name)
+
+	_, err = u.asgClient.UpdateAutoScalingGroup(context.Background(), &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(asgName),
+		LaunchTemplate: &asgTypes.LaunchTemplateSpecification{
+			LaunchTemplateId: out.LaunchTemplate.LaunchTemplateId,
+			Version:          aws.String("$Latest"),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to update ASG %s to use launch template %s, error: %w", asgName, name, err)
+	}
+	u.logger.Printf("ASG %s updated to use launch template %s\n", asgName, name)
+
+	return out.LaunchTemplate, nil
+}
+
+// launchTemplateDataFromLaunchConfiguration creates launch template data equivalent to the launch
+// configuration
+func launchTemplateDataFromLaunchConfiguration(lc asgTypes.LaunchConfiguration) *ec2types.RequestLaunchTemplateData {
+	data := &ec2types.RequestLaunchTemplateData{
+		EbsOptimized: lc.EbsOptimized,
+		ImageId:      lc.ImageId,
+		InstanceType: ec2types.InstanceType(aws.ToString(lc.InstanceType)),
+		KernelId:     nonEmptyString(lc.KernelId),
+		KeyName:      nonEmptyString(lc.KeyName),
+		RamDiskId:    nonEmptyString(lc.RamdiskId),
+		// launch configuration user data is returned base64 encoded, as launch templates require
+		UserData: nonEmptyString(lc.UserData),
+	}
+
+	if profile := aws.ToString(lc.IamInstanceProfile); profile != "" {
+		data.IamInstanceProfile = &ec2types.LaunchTemplateIamInstanceProfileSpecificationRequest{}
+		if strings.HasPrefix(profile, "arn:") {
+			data.IamInstanceProfile.Arn = aws.String(profile)
+		} else {
+			data.IamInstanceProfile.Name = aws.String(profile)
+		}
+	}
+
+	// security groups move to the network interface when it needs a public IP address setting
+	if lc.AssociatePublicIpAddress != nil {
+		data.NetworkInterfaces = []ec2types.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{{
+			AssociatePublicIpAddress: lc.AssociatePublicIpAddress,
+			DeleteOnTermination:      aws.Bool(true),
+			DeviceIndex:              aws.Int32(0),
+			Groups:                   lc.SecurityGroups,
+		}}
+	} else {
+		for _, sg := range lc.SecurityGroups {
+			if strings.HasPrefix(sg, "sg-") {
+				data.SecurityGroupIds = append(data.SecurityGroupIds, sg)
+			} else {
+				data.SecurityGroups = append(data.SecurityGroups, sg)
+			}
+		}
+	}
+
+	for _, b := range lc.BlockDeviceMappings {
+		mapping := ec2types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName:  b.DeviceName,
+			VirtualName: b.VirtualName,
+		}
+		if aws.ToBool(b.NoDevice) {
+			mapping.NoDevice = aws.String("")
+		}
+		if b.Ebs != nil {
+			mapping.Ebs = &ec2types.LaunchTemplateEbsBlockDeviceRequest{
+				DeleteOnTermination: b.Ebs.DeleteOnTermination,
+				Encrypted:           b.Ebs.Encrypted,
+				Iops:                b.Ebs.Iops,
+				SnapshotId:          b.Ebs.SnapshotId,
+				Throughput:          b.Ebs.Throughput,
+				VolumeSize:          b.Ebs.VolumeSize,
+				VolumeType:          ec2types.VolumeType(aws.ToString(b.Ebs.VolumeType)),
+			}
+		}
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, mapping)
+	}
+
+	if lc.MetadataOptions != nil {
+		data.MetadataOptions = &ec2types.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpEndpoint:            ec2types.LaunchTemplateInstanceMetadataEndpointState(lc.MetadataOptions.HttpEndpoint),
+			HttpPutResponseHopLimit: lc.MetadataOptions.HttpPutResponseHopLimit,
+			HttpTokens:              ec2types.LaunchTemplateHttpTokensState(lc.MetadataOptions.HttpTokens),
+		}
+	}
+
+	if lc.InstanceMonitoring != nil {
+		data.Monitoring = &ec2types.LaunchTemplatesMonitoringRequest{Enabled: lc.InstanceMonitoring.Enabled}
+	}
+
+	if tenancy := aws.ToString(lc.PlacementTenancy); tenancy != "" {
+		data.Placement = &ec2types.LaunchTemplatePlacementRequest{Tenancy: ec2types.Tenancy(tenancy)}
+	}
+
+	if price := aws.ToString(lc.SpotPrice); price != "" {
+		data.InstanceMarketOptions = &ec2types.LaunchTemplateInstanceMarketOptionsRequest{
+			MarketType:  ec2types.MarketTypeSpot,
+			SpotOptions: &ec2types.LaunchTemplateSpotMarketOptionsRequest{MaxPrice: aws.String(price)},
+		}
+	}
+
+	return data
+}
+
+// nonEmptyString returns nil for an empty string, which launch templates reject for several fields that
+// launch configurations return as empty strings
+func nonEmptyString(s *string) *string {
+	if aws.ToString(s) == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package ead
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestLaunchTemplateDataFromLaunchConfiguration(t *testing.T) {
+	lc := asgTypes.LaunchConfiguration{
+		ImageId:            aws.String("ami-123"),
+		InstanceType:       aws.String("m5.large"),
+		IamInstanceProfile: aws.String("arn:aws:iam::111111111111:instance-profile/ecs"),
+		KernelId:           aws.String(""),
+		KeyName:            aws.String(""),
+		RamdiskId:          aws.String(""),
+		SecurityGroups:     []string{"sg-111", "sg-222"},
+		UserData:           aws.String("IyEvYmluL2Jhc2g="),
+		BlockDeviceMappings: []asgTypes.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs:        &asgTypes.Ebs{VolumeSize: aws.Int32(30), VolumeType: aws.String("gp3")},
+			},
+			{DeviceName: aws.String("/dev/xvdb"), NoDevice: aws.Bool(true)},
+		},
+		InstanceMonitoring: &asgTypes.InstanceMonitoring{Enabled: aws.Bool(true)},
+		MetadataOptions: &asgTypes.InstanceMetadataOptions{
+			HttpEndpoint:            asgTypes.InstanceMetadataEndpointStateEnabled,
+			HttpPutResponseHopLimit: aws.Int32(2),
+			HttpTokens:              asgTypes.InstanceMetadataHttpTokensStateRequired,
+		},
+	}
+
+	data := launchTemplateDataFromLaunchConfiguration(lc)
+
+	if *data.ImageId != "ami-123" || data.InstanceType != ec2types.InstanceTypeM5Large {
+		t.Errorf("got image %s and instance type %s", *data.ImageId, data.InstanceType)
+	}
+	if data.KernelId != nil || data.KeyName != nil || data.RamDiskId != nil {
+		t.Error("empty strings should be converted to nil")
+	}
+	if aws.ToString(data.IamInstanceProfile.Arn) != *lc.IamInstanceProfile || data.IamInstanceProfile.Name != nil {
+		t.Error("instance profile ARN not converted")
+	}
+	if len(data.SecurityGroupIds) != 2 || len(data.NetworkInterfaces) != 0 {
+		t.Errorf("got security group IDs %v and %d network interfaces", data.SecurityGroupIds, len(data.NetworkInterfaces))
+	}
+	if *data.UserData != *lc.UserData {
+		t.Error("user data not copied")
+	}
+	if len(data.BlockDeviceMappings) != 2 {
+		t.Fatalf("got %d block device mappings, want 2", len(data.BlockDeviceMappings))
+	}
+	if root := data.BlockDeviceMappings[0]; *root.Ebs.VolumeSize != 30 || root.Ebs.VolumeType != ec2types.VolumeTypeGp3 {
+		t.Error("root volume not converted")
+	}
+	if noDevice := data.BlockDeviceMappings[1].NoDevice; noDevice == nil || *noDevice != "" {
+		t.Error("NoDevice not converted")
+	}
+	if data.MetadataOptions.HttpTokens != ec2types.LaunchTemplateHttpTokensStateRequired ||
+		*data.MetadataOptions.HttpPutResponseHopLimit != 2 {
+		t.Error("metadata options not converted")
+	}
+	if !*data.Monitoring.Enabled {
+		t.Error("monitoring not converted")
+	}
+
+	lc.AssociatePublicIpAddress = aws.Bool(true)
+	data = launchTemplateDataFromLaunchConfiguration(lc)
+	if len(data.SecurityGroupIds) != 0 || len(data.NetworkInterfaces) != 1 || len(data.NetworkInterfaces[0].Groups) != 2 {
+		t.Error("security groups should move to the network interface when a public IP setting is present")
+	}
+}
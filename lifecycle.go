@@ -0,0 +1,110 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// scaleOutAsgInstances tags the instances for termination and raises the ASG's desired capacity by the number
+// of instances, so that replacements are launched while the old instances stay in the ASG. It then waits until
+// the ASG has the given number of in service instances running the new image.
+func (u *Upgrader) scaleOutAsgInstances(asgName string, instanceIDs []string, imageID string, inService int32) error {
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+
+	u.logger.Println("Tagging existing instances for later verification that they have been terminated")
+	if err := u.tagInstancesForTermination(asgName, instanceIDs); err != nil {
+		return err
+	}
+
+	asg, err := u.getAsgByName(asgName)
+	if err != nil {
+		return err
+	}
+	desired := aws.ToInt32(asg.DesiredCapacity) + int32(len(instanceIDs))
+
+	u.logger.Printf("Scaling ASG %s out to %d instances to replace %d existing instances", asgName, desired, len(instanceIDs))
+	_, err = u.asgClient.SetDesiredCapacity(context.Background(), &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String(asgName),
+		DesiredCapacity:      aws.Int32(desired),
+		HonorCooldown:        aws.Bool(false),
+	})
+	if err != nil {
+		return fmt.Errorf("error trying to scale out ASG %s: %w", asgName, err)
+	}
+
+	u.logger.Printf("New instances starting soon, will wait up to %s", u.inServiceTimeout)
+	return u.waitForNewAsgInstances(asgName, imageID, inService)
+}
+
+// terminateAsgInstance terminates an instance through its ASG, so that termination lifecycle hooks run, and
+// waits for the instance to leave the ASG. With decrement, the ASG's desired capacity is lowered instead of
+// launching a replacement.
+func (u *Upgrader) terminateAsgInstance(instanceID string, decrement bool) error {
+	u.logger.Printf("Terminating instance %s through its ASG", instanceID)
+	_, err := u.asgClient.TerminateInstanceInAutoScalingGroup(context.Background(),
+		&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     aws.String(instanceID),
+			ShouldDecrementDesiredCapacity: aws.Bool(decrement),
+		})
+	if err != nil {
+		return fmt.Errorf("failed to terminate instance %s in its ASG: %w", instanceID, err)
+	}
+
+	state := ""
+	startTime := time.Now()
+	for {
+		if time.Since(startTime) >= u.lifecycleHookTimeout {
+			return fmt.Errorf("timeout while waiting for instance %s to leave its ASG, last in state %s",
+				instanceID, state)
+		}
+		if err := u.pause(u.pollingInterval); err != nil {
+			return err
+		}
+
+		instance, err := u.describeAsgInstance(instanceID)
+		if err != nil {
+			return err
+		}
+		if instance == nil {
+			u.logger.Printf("Instance %s has left its ASG", instanceID)
+			return nil
+		}
+		if s := aws.ToString(instance.LifecycleState); s != state {
+			state = s
+			u.logger.Printf("Instance %s is in lifecycle state %s", instanceID, state)
+		}
+	}
+}
+
+// describeAsgInstance returns the ASG details of the instance, or nil if it isn't in an ASG
+func (u *Upgrader) describeAsgInstance(instanceID string) (*asgTypes.AutoScalingInstanceDetails, error) {
+	result, err := u.asgClient.DescribeAutoScalingInstances(context.Background(),
+		&autoscaling.DescribeAutoScalingInstancesInput{InstanceIds: []string{instanceID}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing ASG instance %s: %w", instanceID, err)
+	}
+	for _, i := range result.AutoScalingInstances {
+		if aws.ToString(i.InstanceId) == instanceID {
+			return &i, nil
+		}
+	}
+	return nil, nil
+}
+
+// lifecycleHookWaits returns the number of instances that are waiting on launch lifecycle hooks
+func lifecycleHookWaits(instances []asgTypes.Instance) int {
+	waiting := 0
+	for _, i := range instances {
+		if i.LifecycleState == asgTypes.LifecycleStatePendingWait || i.LifecycleState == asgTypes.LifecycleStatePendingProceed {
+			waiting++
+		}
+	}
+	return waiting
+}
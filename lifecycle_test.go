@@ -0,0 +1,23 @@
+package ead
+
+import (
+	"testing"
+
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+func TestLifecycleHookWaits(t *testing.T) {
+	instances := []asgTypes.Instance{
+		{LifecycleState: asgTypes.LifecycleStateInService},
+		{LifecycleState: asgTypes.LifecycleStatePendingWait},
+		{LifecycleState: asgTypes.LifecycleStatePendingProceed},
+		{LifecycleState: asgTypes.LifecycleStatePending},
+		{LifecycleState: asgTypes.LifecycleStateTerminatingWait},
+	}
+	if got := lifecycleHookWaits(instances); got != 2 {
+		t.Errorf("got %d instances waiting on launch lifecycle hooks, want 2", got)
+	}
+	if got := lifecycleHookWaits(nil); got != 0 {
+		t.Errorf("got %d instances waiting without instances, want 0", got)
+	}
+}
@@ -0,0 +1,296 @@
+package ead
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// Lease is the upgrade lock on a cluster, stored in a tag on the cluster's ASG
+type Lease struct {
+	Owner   string
+	RunID   string
+	Expires time.Time
+}
+
+// String formats the lease as a tag value, for example:
+//
+//	owner=alice@ops-host run=9f86d081884c7d65 expires=2024-02-01T10:15:00Z
+func (l Lease) String() string {
+	return fmt.Sprintf("owner=%s run=%s expires=%s", strings.ReplaceAll(l.Owner, " ", "_"), l.RunID,
+		l.Expires.UTC().Format(time.RFC3339))
+}
+
+// parseLease reads a lease from a tag value
+func parseLease(value string) (Lease, error) {
+	var l Lease
+	for _, f := range strings.Fields(value) {
+		key, v, _ := strings.Cut(f, "=")
+		switch key {
+		case "owner":
+			l.Owner = v
+		case "run":
+			l.RunID = v
+		case "expires":
+			expires, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return Lease{}, fmt.Errorf("invalid lease expiry %q: %w", v, err)
+			}
+			l.Expires = expires
+		}
+	}
+	if l.RunID == "" || l.Expires.IsZero() {
+		return Lease{}, fmt.Errorf("invalid lease %q", value)
+	}
+	return l, nil
+}
+
+// defaultLockOwner identifies the user and host running the upgrade
+func defaultLockOwner() string {
+	owner := "unknown"
+	if u, err := user.Current(); err == nil {
+		owner = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		owner += "@" + host
+	}
+	return owner
+}
+
+// lockSettleTime is the least time to wait after writing a lease before reading it back
+const lockSettleTime = 10 * time.Second
+
+// errLockLost is the cause of an upgrade stopping because it no longer holds the upgrade lock
+var errLockLost = errors.New("lost the upgrade lock")
+
+// acquireLock takes the upgrade lock on the ASG, unless another run holds a lease that hasn't expired. After
+// writing the lease it is read back, so that if two runs break an expired lease at the same time, only the
+// one whose lease was written last proceeds. The lease is refreshed until the returned release function is
+// called. If the lock is lost while the upgrade is running, the upgrade's wait loops return an error.
+func (u *Upgrader) acquireLock(asgName string) (release func(), err error) {
+	current, found, err := u.getLease(asgName)
+	if err != nil {
+		return nil, err
+	}
+	if found && current.RunID != u.runID {
+		if time.Now().Before(current.Expires) {
+			return nil, fmt.Errorf("cluster %s is locked by %s (run %s) until %s", u.cluster, current.Owner,
+				current.RunID, current.Expires.Format(time.RFC3339))
+		}
+		u.logger.Printf("Breaking expired lock held by %s (run %s), which expired at %s", current.Owner,
+			current.RunID, current.Expires.Format(time.RFC3339))
+	}
+
+	if err := u.putLease(asgName); err != nil {
+		return nil, err
+	}
+
+	// give a competing run's write time to land before checking who holds the lease. A write that lands even
+	// later is found by the next refresh, which stops this run.
+	time.Sleep(max(u.pollingInterval, lockSettleTime))
+	current, found, err = u.getLease(asgName)
+	if err != nil {
+		return nil, err
+	}
+	if !found || current.RunID != u.runID {
+		return nil, fmt.Errorf("lost the race for the lock on cluster %s to %s (run %s)", u.cluster, current.Owner, current.RunID)
+	}
+	u.logger.Printf("Acquired lock on cluster %s for run %s", u.cluster, u.runID)
+
+	lockCtx, lose := context.WithCancelCause(context.Background())
+	u.lockCtx = lockCtx
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		u.refreshLock(ctx, lockRefreshInterval(u.lockTTL), func() error { return u.renewLease(asgName) }, lose)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		lose(nil)
+		u.lockCtx = nil
+		if err := u.releaseLock(asgName); err != nil {
+			u.logger.Printf("Unable to release lock on cluster %s, it will expire at the end of its lease: %s", u.cluster, err)
+		}
+	}, nil
+}
+
+// lockRefreshInterval is how often the lease is checked and renewed, often enough that a run which took the
+// lock is noticed quickly and a few failed renewals don't let the lease expire
+func lockRefreshInterval(ttl time.Duration) time.Duration {
+	return min(ttl/3, time.Minute)
+}
+
+// refreshLock calls renew every interval until ctx is done. If renew finds that another run has taken the
+// lock, or the lease can't be renewed before it expires, lose is called with the reason and refreshing stops.
+func (u *Upgrader) refreshLock(ctx context.Context, interval time.Duration, renew func() error,
+	lose context.CancelCauseFunc,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := renew()
+		switch {
+		case err == nil:
+			renewed = time.Now()
+		case errors.Is(err, errLockLost):
+			u.logger.Printf("Stopping upgrade: %s", err)
+			lose(err)
+			return
+		case time.Since(renewed)+interval >= u.lockTTL:
+			// the lease would expire before the next attempt, after which another run could take the lock
+			err = fmt.Errorf("%w on cluster %s, it couldn't be renewed before it expires: %w", errLockLost, u.cluster, err)
+			u.logger.Printf("Stopping upgrade: %s", err)
+			lose(err)
+			return
+		default:
+			u.logger.Printf("Unable to refresh lock on cluster %s, will retry: %s", u.cluster, err)
+		}
+	}
+}
+
+// renewLease extends this run's lease. If another run has taken the lock or the lock was removed, an error
+// wrapping errLockLost is returned.
+func (u *Upgrader) renewLease(asgName string) error {
+	current, found, err := u.getLease(asgName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w on cluster %s, it was removed", errLockLost, u.cluster)
+	}
+	if current.RunID != u.runID {
+		return fmt.Errorf("%w on cluster %s to %s (run %s)", errLockLost, u.cluster, current.Owner, current.RunID)
+	}
+	return u.putLease(asgName)
+}
+
+// lockContext returns a context that is canceled, with the reason as its cause, if the upgrade lock is lost
+func (u *Upgrader) lockContext() context.Context {
+	if u.lockCtx == nil {
+		return context.Background()
+	}
+	return u.lockCtx
+}
+
+// lockErr returns the reason the upgrade lock was lost, or nil if it is still held
+func (u *Upgrader) lockErr() error {
+	return context.Cause(u.lockContext())
+}
+
+// pause waits for d, returning the reason early if the upgrade lock is lost in the meantime
+func (u *Upgrader) pause(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-u.lockContext().Done():
+		return u.lockErr()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// releaseLock removes the lease if it still belongs to this run
+func (u *Upgrader) releaseLock(asgName string) error {
+	current, found, err := u.getLease(asgName)
+	if err != nil {
+		return err
+	}
+	if !found || current.RunID != u.runID {
+		return nil
+	}
+	return u.deleteLease(asgName)
+}
+
+// Unlock removes the upgrade lock from the cluster regardless of who holds it, for recovery after a run was
+// killed. It returns the lease that was removed, or nil if the cluster wasn't locked.
+func (u *Upgrader) Unlock() (*Lease, error) {
+	if u.cluster == "" {
+		return nil, fmt.Errorf("cluster name must be set in config to unlock")
+	}
+
+	asgName, err := u.getAsgNameForCluster(u.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	current, found, err := u.getLease(asgName)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	if err := u.deleteLease(asgName); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// getLease reads the lease from the ASG's lock tag. An unreadable lease is treated as expired.
+func (u *Upgrader) getLease(asgName string) (Lease, bool, error) {
+	asg, err := u.getAsgByName(asgName)
+	if err != nil {
+		return Lease{}, false, err
+	}
+
+	value, found := asgTagValue(asg, TagNameLock)
+	if !found {
+		return Lease{}, false, nil
+	}
+
+	lease, err := parseLease(value)
+	if err != nil {
+		u.logger.Printf("Ignoring invalid lock on ASG %s: %s", asgName, err)
+		return Lease{Owner: "unknown", RunID: "unknown"}, true, nil
+	}
+	return lease, true, nil
+}
+
+func (u *Upgrader) putLease(asgName string) error {
+	lease := Lease{Owner: u.lockOwner, RunID: u.runID, Expires: time.Now().Add(u.lockTTL)}
+	_, err := u.asgClient.CreateOrUpdateTags(context.Background(), &autoscaling.CreateOrUpdateTagsInput{
+		Tags: []asgTypes.Tag{{
+			Key:               aws.String(TagNameLock),
+			Value:             aws.String(lease.String()),
+			PropagateAtLaunch: aws.Bool(false),
+			ResourceId:        aws.String(asgName),
+			ResourceType:      aws.String("auto-scaling-group"),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write lock on ASG %s: %w", asgName, err)
+	}
+	return nil
+}
+
+func (u *Upgrader) deleteLease(asgName string) error {
+	_, err := u.asgClient.DeleteTags(context.Background(), &autoscaling.DeleteTagsInput{
+		Tags: []asgTypes.Tag{{
+			Key:          aws.String(TagNameLock),
+			ResourceId:   aws.String(asgName),
+			ResourceType: aws.String("auto-scaling-group"),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove lock from ASG %s: %w", asgName, err)
+	}
+	return nil
+}
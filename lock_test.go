@@ -0,0 +1,103 @@
+package ead
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	lease := Lease{
+		Owner:   "alice@ops host",
+		RunID:   "9f86d081884c7d65",
+		Expires: time.Date(2024, 2, 1, 10, 15, 0, 0, time.UTC),
+	}
+
+	value := lease.String()
+	if want := "owner=alice@ops_host run=9f86d081884c7d65 expires=2024-02-01T10:15:00Z"; value != want {
+		t.Errorf("String() = %q, want %q", value, want)
+	}
+
+	got, err := parseLease(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Owner != "alice@ops_host" || got.RunID != lease.RunID || !got.Expires.Equal(lease.Expires) {
+		t.Errorf("parseLease() = %+v", got)
+	}
+
+	for _, invalid := range []string{"", "owner=bob", "run=abc expires=tomorrow"} {
+		if _, err := parseLease(invalid); err == nil {
+			t.Errorf("parseLease(%q) should return an error", invalid)
+		}
+	}
+}
+
+func TestRefreshLockLost(t *testing.T) {
+	taken := fmt.Errorf("%w on cluster test to bob (run 123)", errLockLost)
+	tests := []struct {
+		name  string
+		renew func() error
+		want  error
+	}{
+		{
+			name:  "taken by another run",
+			renew: func() error { return taken },
+			want:  taken,
+		},
+		{
+			name:  "renewal keeps failing",
+			renew: func() error { return errors.New("throttled") },
+			want:  errLockLost,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Upgrader{cluster: "test", failurePolicy: FailurePolicyRestore, lockTTL: 50 * time.Millisecond,
+				logger: log.Default()}
+			lockCtx, lose := context.WithCancelCause(context.Background())
+			u.lockCtx = lockCtx
+
+			// returns once the lock is lost
+			u.refreshLock(context.Background(), 10*time.Millisecond, tt.renew, lose)
+
+			if err := u.pause(time.Hour); !errors.Is(err, tt.want) {
+				t.Errorf("pause() = %v, want %v", err, tt.want)
+			}
+
+			// nothing is restored, which would fail without AWS clients
+			abortErr := errors.New("waiting failed")
+			if err := u.abortUpgrade(&upgradeRun{asgName: "asg"}, abortErr); err != abortErr {
+				t.Errorf("abortUpgrade() = %v, want %v", err, abortErr)
+			}
+		})
+	}
+
+	t.Run("renewed", func(t *testing.T) {
+		u := &Upgrader{cluster: "test", lockTTL: time.Second, logger: log.Default()}
+		lockCtx, lose := context.WithCancelCause(context.Background())
+		defer lose(nil)
+		u.lockCtx = lockCtx
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		renewals := 0
+		u.refreshLock(ctx, 10*time.Millisecond, func() error {
+			renewals++
+			if renewals%2 == 0 {
+				return errors.New("throttled")
+			}
+			return nil
+		}, lose)
+
+		if err := u.lockErr(); err != nil {
+			t.Errorf("lock lost after %d renewals: %s", renewals, err)
+		}
+		if err := u.pause(time.Millisecond); err != nil {
+			t.Errorf("pause() = %v", err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package ead
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// overrideLaunchTemplate is a launch template referenced by a mixed instances policy override that is
+// different from the ASG's main launch template
+type overrideLaunchTemplate struct {
+	lt     *ec2types.LaunchTemplate
+	ltData *ec2types.ResponseLaunchTemplateData
+	image  ec2types.Image
+}
+
+// getOverrideLaunchTemplates returns the distinct launch templates referenced by the overrides in the
+// ASG's mixed instances policy, other than the main launch template. Since the overrides get new versions
+// with the same image as the main template, an error is returned if any of them currently use an image
+// with a different architecture.
+func (u *Upgrader) getOverrideLaunchTemplates(group *asgTypes.AutoScalingGroup, main *ec2types.LaunchTemplate,
+	image ec2types.Image) ([]overrideLaunchTemplate, error) {
+
+	if group.MixedInstancesPolicy == nil || group.MixedInstancesPolicy.LaunchTemplate == nil {
+		return nil, nil
+	}
+
+	seen := map[string]bool{*main.LaunchTemplateId: true}
+	var overrides []overrideLaunchTemplate
+	for _, o := range group.MixedInstancesPolicy.LaunchTemplate.Overrides {
+		if o.LaunchTemplateSpecification == nil {
+			continue
+		}
+
+		lt, err := u.describeLaunchTemplate(o.LaunchTemplateSpecification)
+		if err != nil {
+			return nil, fmt.Errorf("%w for mixed instances policy override", err)
+		}
+		if seen[*lt.LaunchTemplateId] {
+			continue
+		}
+		seen[*lt.LaunchTemplateId] = true
+
+		version := aws.ToString(o.LaunchTemplateSpecification.Version)
+		if version == "" {
+			version = "$Default"
+		}
+		ltData, err := u.getLaunchTemplateData(lt, version)
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := u.getImageByID(*ltData.ImageId)
+		if err != nil {
+			return nil, err
+		}
+		if current.Architecture != image.Architecture {
+			return nil, fmt.Errorf("override launch template %s uses a %s image, but the new image %s is %s",
+				*lt.LaunchTemplateName, current.Architecture, *image.ImageId, image.Architecture)
+		}
+
+		u.logger.Printf("Mixed instances policy override uses launch template %s\n", *lt.LaunchTemplateName)
+		overrides = append(overrides, overrideLaunchTemplate{lt: lt, ltData: ltData, image: current})
+	}
+
+	return overrides, nil
+}
+
+// mixedInstancesPolicyWithVersions returns a copy of the policy in which the main launch template and any
+// overrides that use one of the given launch template versions refer to that version instead, in the same
+// style as before. Instance type overrides, weights and the instances distribution are preserved.
+func mixedInstancesPolicyWithVersions(policy *asgTypes.MixedInstancesPolicy,
+	versions ...*ec2types.LaunchTemplateVersion) *asgTypes.MixedInstancesPolicy {
+
+	newSpec := func(spec *asgTypes.LaunchTemplateSpecification) *asgTypes.LaunchTemplateSpecification {
+		if spec == nil {
+			return nil
+		}
+		for _, v := range versions {
+			if aws.ToString(spec.LaunchTemplateId) == *v.LaunchTemplateId ||
+				aws.ToString(spec.LaunchTemplateName) == *v.LaunchTemplateName {
+				return &asgTypes.LaunchTemplateSpecification{
+					LaunchTemplateId: v.LaunchTemplateId,
+					Version:          aws.String(versionReference(spec.Version, *v.VersionNumber)),
+				}
+			}
+		}
+		return launchTemplateSpecForUpdate(spec)
+	}
+
+	ltPolicy := *policy.LaunchTemplate
+	ltPolicy.LaunchTemplateSpecification = newSpec(ltPolicy.LaunchTemplateSpecification)
+	ltPolicy.Overrides = make([]asgTypes.LaunchTemplateOverrides, len(policy.LaunchTemplate.Overrides))
+	for i, o := range policy.LaunchTemplate.Overrides {
+		o.LaunchTemplateSpecification = newSpec(o.LaunchTemplateSpecification)
+		ltPolicy.Overrides[i] = o
+	}
+
+	return &asgTypes.MixedInstancesPolicy{
+		InstancesDistribution: policy.InstancesDistribution,
+		LaunchTemplate:        &ltPolicy,
+	}
+}
+
+// launchTemplateSpecForUpdate returns a copy of spec that UpdateAutoScalingGroup accepts. ASGs are described with
+// both the launch template ID and name, but only one of them may be given in an update.
+func launchTemplateSpecForUpdate(spec *asgTypes.LaunchTemplateSpecification) *asgTypes.LaunchTemplateSpecification {
+	if spec == nil {
+		return nil
+	}
+	newSpec := &asgTypes.LaunchTemplateSpecification{Version: spec.Version}
+	if spec.LaunchTemplateId != nil {
+		newSpec.LaunchTemplateId = spec.LaunchTemplateId
+	} else {
+		newSpec.LaunchTemplateName = spec.LaunchTemplateName
+	}
+	return newSpec
+}
@@ -0,0 +1,81 @@
+package ead
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestMixedInstancesPolicyWithVersions(t *testing.T) {
+	policy := &asgTypes.MixedInstancesPolicy{
+		InstancesDistribution: &asgTypes.InstancesDistribution{
+			OnDemandBaseCapacity:   aws.Int32(1),
+			SpotAllocationStrategy: aws.String("price-capacity-optimized"),
+		},
+		LaunchTemplate: &asgTypes.LaunchTemplate{
+			LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+				LaunchTemplateId:   aws.String("lt-main"),
+				LaunchTemplateName: aws.String("ecs-prod"),
+				Version:            aws.String("$Latest"),
+			},
+			Overrides: []asgTypes.LaunchTemplateOverrides{
+				{InstanceType: aws.String("m5.large"), WeightedCapacity: aws.String("1")},
+				{
+					InstanceType:                aws.String("m5.xlarge"),
+					WeightedCapacity:            aws.String("2"),
+					LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{LaunchTemplateName: aws.String("ecs-prod-large")},
+				},
+				{
+					InstanceType: aws.String("m5.2xlarge"),
+					LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+						LaunchTemplateId:   aws.String("lt-other"),
+						LaunchTemplateName: aws.String("ecs-other"),
+					},
+				},
+			},
+		},
+	}
+
+	main := &ec2types.LaunchTemplateVersion{
+		LaunchTemplateId:   aws.String("lt-main"),
+		LaunchTemplateName: aws.String("ecs-prod"),
+		VersionNumber:      aws.Int64(8),
+	}
+	large := &ec2types.LaunchTemplateVersion{
+		LaunchTemplateId:   aws.String("lt-large"),
+		LaunchTemplateName: aws.String("ecs-prod-large"),
+		VersionNumber:      aws.Int64(3),
+	}
+
+	got := mixedInstancesPolicyWithVersions(policy, main, large)
+
+	if got.InstancesDistribution != policy.InstancesDistribution {
+		t.Error("instances distribution was not preserved")
+	}
+	if spec := got.LaunchTemplate.LaunchTemplateSpecification; *spec.LaunchTemplateId != "lt-main" || *spec.Version != "$Latest" {
+		t.Errorf("main launch template is %s version %s, want lt-main version $Latest", *spec.LaunchTemplateId, *spec.Version)
+	}
+	if len(got.LaunchTemplate.Overrides) != 3 {
+		t.Fatalf("got %d overrides, want 3", len(got.LaunchTemplate.Overrides))
+	}
+	if got.LaunchTemplate.Overrides[0].LaunchTemplateSpecification != nil {
+		t.Error("override without a launch template should not get one")
+	}
+	if spec := got.LaunchTemplate.Overrides[1].LaunchTemplateSpecification; aws.ToString(spec.LaunchTemplateId) != "lt-large" ||
+		aws.ToString(spec.Version) != "$Default" {
+		t.Errorf("override launch template is %s version %s, want lt-large version $Default",
+			aws.ToString(spec.LaunchTemplateId), aws.ToString(spec.Version))
+	}
+	if *got.LaunchTemplate.Overrides[1].WeightedCapacity != "2" || *got.LaunchTemplate.Overrides[1].InstanceType != "m5.xlarge" {
+		t.Error("override instance type and weight were not preserved")
+	}
+	if spec := got.LaunchTemplate.Overrides[2].LaunchTemplateSpecification; *spec.LaunchTemplateId != "lt-other" || spec.LaunchTemplateName != nil ||
+		spec.Version != nil {
+		t.Error("override for a template without a new version should only keep its launch template ID")
+	}
+	if policy.LaunchTemplate.Overrides[1].LaunchTemplateSpecification.LaunchTemplateId != nil {
+		t.Error("original policy was modified")
+	}
+}
@@ -0,0 +1,185 @@
+package ead
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// LaunchTemplateMutation is passed to each LaunchTemplateMutator while a new launch template version is
+// prepared. Mutators may rewrite UserData or change LaunchTemplateData directly. ImageId, KernelId and
+// RamDiskId have already been set to the new image.
+type LaunchTemplateMutation struct {
+	Cluster  string
+	OldImage ec2types.Image
+	NewImage ec2types.Image
+
+	// UserData is the decoded user data. It is encoded again after all mutators have run.
+	UserData string
+
+	LaunchTemplateData *ec2types.RequestLaunchTemplateData
+}
+
+// LaunchTemplateMutator changes launch template data that must be updated along with the image, such as
+// user data that differs between AMI families
+type LaunchTemplateMutator func(m *LaunchTemplateMutation) error
+
+// UserDataTemplateMutator returns a LaunchTemplateMutator that replaces the user data with the result of
+// a Go text/template. The template is executed with the LaunchTemplateMutation, so it can refer to
+// fields like {{.Cluster}}, {{.NewImage.ImageId}}, {{.NewImage.Name}} and the current {{.UserData}}.
+func UserDataTemplateMutator(text string) (LaunchTemplateMutator, error) {
+	tmpl, err := template.New("user-data").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user data template: %w", err)
+	}
+
+	return func(m *LaunchTemplateMutation) error {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, m); err != nil {
+			return fmt.Errorf("failed to render user data template: %w", err)
+		}
+		m.UserData = buf.String()
+		return nil
+	}, nil
+}
+
+// preparedLaunchTemplateData is the data for a new launch template version along with a summary of the
+// changes made to it, other than the image
+type preparedLaunchTemplateData struct {
+	data               *ec2types.RequestLaunchTemplateData
+	oldImage           ec2types.Image
+	newImage           ec2types.Image
+	blockDeviceChanges []string
+	userDataDiff       string
+}
+
+// prepareLaunchTemplateData makes the request data for a new launch template version that uses newImage
+// and instanceType, if set, reconciles its block device mappings with the new image and runs the configured
+// mutators on it. Any problem is found here, before anything is changed. The instance type is only set on the
+// ASG's main launch template, since override launch templates may be shared with other ASGs.
+func (u *Upgrader) prepareLaunchTemplateData(ltd *ec2types.ResponseLaunchTemplateData, oldImage,
+	newImage ec2types.Image, instanceType string) (preparedLaunchTemplateData, error) {
+
+	newLtd, err := makeLaunchTemplateDataRequest(ltd)
+	if err != nil {
+		return preparedLaunchTemplateData{}, err
+	}
+
+	newLtd.ImageId = newImage.ImageId
+
+	// KernelId and RamdiskId must be updated anytime a the ImageId is updated
+	newLtd.KernelId = newImage.KernelId
+	newLtd.RamDiskId = newImage.RamdiskId
+
+	if instanceType != "" {
+		newLtd.InstanceType = ec2types.InstanceType(instanceType)
+	}
+
+	prepared := preparedLaunchTemplateData{oldImage: oldImage, newImage: newImage}
+	newLtd.BlockDeviceMappings, prepared.blockDeviceChanges, err = reconcileBlockDeviceMappings(
+		newLtd.BlockDeviceMappings, oldImage, newImage)
+	if err != nil {
+		return preparedLaunchTemplateData{}, err
+	}
+
+	// If newLtv has an SSH key name and it's empty, change to nil as empty is not valid
+	if newLtd.KeyName != nil && *newLtd.KeyName == "" {
+		newLtd.KeyName = nil
+	}
+
+	prepared.data = newLtd
+	if len(u.launchTemplateMutators) == 0 {
+		return prepared, nil
+	}
+
+	var userData string
+	if newLtd.UserData != nil {
+		decoded, err := base64.StdEncoding.DecodeString(*newLtd.UserData)
+		if err != nil {
+			return preparedLaunchTemplateData{}, fmt.Errorf("failed to decode launch template user data: %w", err)
+		}
+		userData = string(decoded)
+	}
+
+	m := LaunchTemplateMutation{
+		Cluster:            u.cluster,
+		OldImage:           oldImage,
+		NewImage:           newImage,
+		UserData:           userData,
+		LaunchTemplateData: newLtd,
+	}
+	for _, mutate := range u.launchTemplateMutators {
+		if err := mutate(&m); err != nil {
+			return preparedLaunchTemplateData{}, err
+		}
+	}
+
+	prepared.data = m.LaunchTemplateData
+	prepared.userDataDiff = diffLines(userData, m.UserData)
+	if prepared.userDataDiff != "" {
+		encoded := base64.StdEncoding.EncodeToString([]byte(m.UserData))
+		prepared.data.UserData = &encoded
+	}
+
+	return prepared, nil
+}
+
+// logChanges logs the changes made to a launch template's data, other than the image
+func (p preparedLaunchTemplateData) logChanges(logger *log.Logger, templateName string) {
+	for _, c := range p.blockDeviceChanges {
+		logger.Printf("Launch template %s: %s\n", templateName, c)
+	}
+	if p.userDataDiff != "" {
+		logger.Printf("User data changes for launch template %s:\n%s", templateName, p.userDataDiff)
+	}
+}
+
+// diffLines returns a line by line diff of two strings, with removed lines prefixed by "-", added lines
+// by "+" and unchanged lines by a space. An empty string is returned if they are equal.
+func diffLines(a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	aLines := strings.Split(a, "\n")
+	bLines := strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of aLines[i:] and bLines[j:]
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			sb.WriteString("  " + aLines[i] + "\n")
+			i++
+			j++
+		case i < len(aLines) && (j == len(bLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + aLines[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + bLines[j] + "\n")
+			j++
+		}
+	}
+
+	return sb.String()
+}
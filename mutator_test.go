@@ -0,0 +1,121 @@
+package ead
+
+import (
+	"encoding/base64"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func Test_diffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "equal", a: "a\nb", b: "a\nb", want: ""},
+		{name: "changed line", a: "a\nb\nc", b: "a\nx\nc", want: "  a\n- b\n+ x\n  c\n"},
+		{name: "added line", a: "a", b: "a\nb", want: "  a\n+ b\n"},
+		{name: "removed line", a: "a\nb", b: "b", want: "- a\n  b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.a, tt.b); got != tt.want {
+				t.Errorf("diffLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgrader_prepareLaunchTemplateData(t *testing.T) {
+	mutator, err := UserDataTemplateMutator("#!/bin/bash\necho ECS_CLUSTER={{.Cluster}} >> /etc/ecs/ecs.config\n" +
+		"# {{.NewImage.Name}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Upgrader{
+		cluster:                "prod",
+		logger:                 log.Default(),
+		launchTemplateMutators: []LaunchTemplateMutator{mutator},
+	}
+
+	userData := "#!/bin/bash\necho ECS_CLUSTER=prod >> /etc/ecs/ecs.config\n"
+	ltd := &ec2types.ResponseLaunchTemplateData{
+		ImageId:  aws.String("ami-old"),
+		KeyName:  aws.String(""),
+		UserData: aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
+		BlockDeviceMappings: []ec2types.LaunchTemplateBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2types.LaunchTemplateEbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+		},
+	}
+	oldImage := ec2types.Image{
+		ImageId:        aws.String("ami-old"),
+		Name:           aws.String("al2023-ami-ecs-hvm-2023.0.20240101"),
+		RootDeviceName: aws.String("/dev/xvda"),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+		},
+	}
+	newImage := ec2types.Image{
+		ImageId:        aws.String("ami-new"),
+		Name:           aws.String("al2023-ami-ecs-hvm-2023.0.20240201"),
+		RootDeviceName: aws.String("/dev/xvda"),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-2")}},
+		},
+	}
+
+	prepared, err := u.prepareLaunchTemplateData(ltd, oldImage, newImage, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, diff := prepared.data, prepared.userDataDiff
+
+	if *data.ImageId != "ami-new" {
+		t.Errorf("image ID is %s, want ami-new", *data.ImageId)
+	}
+	if data.KeyName != nil {
+		t.Error("empty key name should be removed")
+	}
+	if data.BlockDeviceMappings[0].Ebs.SnapshotId != nil {
+		t.Error("snapshot ID should be removed")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(*data.UserData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := userData + "# al2023-ami-ecs-hvm-2023.0.20240201\n"
+	if string(decoded) != want {
+		t.Errorf("user data is %q, want %q", decoded, want)
+	}
+	if !strings.Contains(diff, "+ # al2023-ami-ecs-hvm-2023.0.20240201\n") {
+		t.Errorf("diff doesn't show the added line:\n%s", diff)
+	}
+
+	// without mutators the user data is unchanged
+	u.launchTemplateMutators = nil
+	prepared, err = u.prepareLaunchTemplateData(ltd, oldImage, newImage, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepared.userDataDiff != "" || *prepared.data.UserData != *ltd.UserData {
+		t.Error("user data should not change without mutators")
+	}
+	if prepared.data.InstanceType != ltd.InstanceType {
+		t.Errorf("instance type is %q, want the template's %q", prepared.data.InstanceType, ltd.InstanceType)
+	}
+
+	// the instance type is only set when given, for the main launch template
+	prepared, err = u.prepareLaunchTemplateData(ltd, oldImage, newImage, "m7g.large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepared.data.InstanceType != ec2types.InstanceTypeM7gLarge {
+		t.Errorf("instance type is %q, want m7g.large", prepared.data.InstanceType)
+	}
+}
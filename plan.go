@@ -0,0 +1,159 @@
+package ead
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UpgradePlan describes the changes UpgradeCluster would make, without making any of them
+type UpgradePlan struct {
+	Cluster               string
+	ASGName               string
+	LaunchTemplateName    string
+	LaunchTemplateVersion int64
+	UpgradeNeeded         bool
+	Reason                string
+	Instances             []string
+	AMIDiff               AMIDiff
+
+	// BatchSize is the number of instances that would be replaced at a time, within the capacity limits
+	BatchSize int
+
+	// WarmPoolInstances lists the instances in the ASG's warm pool, which are replaced when the warm pool
+	// is refreshed
+	WarmPoolInstances []string
+
+	// PreflightFindings are the risks found in the cluster's services and tasks
+	PreflightFindings []PreflightFinding
+
+	// StandaloneTasks are the tasks that don't belong to a service on the instances to be replaced, which are
+	// handled according to StandaloneTaskPolicy
+	StandaloneTasks      []StandaloneTask
+	StandaloneTaskPolicy StandaloneTaskPolicy
+
+	// BlockDeviceChanges describes how the block device mappings would be changed to suit the new image
+	BlockDeviceChanges []string
+
+	// UserDataDiff is a line diff of the changes the launch template mutators would make to the user data
+	// of the ASG's launch template
+	UserDataDiff string
+
+	// LaunchTemplateDeletions lists the launch template versions that the retention settings would delete
+	// once the upgrade has added its new version
+	LaunchTemplateDeletions []LaunchTemplateVersionDeletion
+}
+
+// PlanUpgrade performs the same lookups as UpgradeCluster and reports what an upgrade would do. Nothing
+// is changed in the cluster, the ASG, or the launch template.
+func (u *Upgrader) PlanUpgrade() (UpgradePlan, error) {
+	if u.cluster == "" {
+		return UpgradePlan{}, fmt.Errorf("cluster name must be set in config to plan an upgrade")
+	}
+
+	target, err := u.findUpgradeTarget()
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+
+	outdatedFound, err := u.checkRunningInstances(*target.latestImage.ImageId, u.configuredInstanceTypes())
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+
+	instances, err := u.getInstanceIDsForCluster(u.cluster)
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+
+	_, warmPoolInstances, err := u.describeWarmPool(target.asgName)
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+
+	plan := UpgradePlan{
+		Cluster:               u.cluster,
+		ASGName:               target.asgName,
+		LaunchTemplateName:    *target.lt.LaunchTemplateName,
+		LaunchTemplateVersion: *target.lt.LatestVersionNumber,
+		UpgradeNeeded:         true,
+		Instances:             instances,
+		WarmPoolInstances:     warmPoolInstances,
+		AMIDiff:               DiffAMIs(target.currentImage, target.latestImage),
+	}
+
+	switch {
+	case target.isNewer:
+		plan.Reason = "latest image is newer than the image in the launch template"
+	case len(target.instanceTypeChanges) > 0:
+		plan.Reason = strings.Join(target.instanceTypeChanges, ", ")
+	case outdatedFound:
+		plan.Reason = "instances in the cluster are running an older image or instance type"
+	case u.forceReplacement:
+		plan.Reason = "force-replacement is enabled"
+	default:
+		plan.UpgradeNeeded = false
+		plan.Reason = "cluster is already running the latest AMI"
+	}
+
+	// old launch template versions are only cleaned up after an upgrade
+	if !plan.UpgradeNeeded {
+		return plan, nil
+	}
+
+	report, err := u.Preflight()
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+	plan.PreflightFindings = report.Findings
+
+	standaloneTasks, err := u.listStandaloneTasks()
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+	for _, id := range instances {
+		plan.StandaloneTasks = append(plan.StandaloneTasks, standaloneTasks[id]...)
+	}
+	plan.StandaloneTaskPolicy = u.standaloneTaskPolicy
+
+	prepared, err := u.prepareLaunchTemplateData(target.ltData, target.currentImage, target.latestImage,
+		u.instanceType)
+	if err != nil {
+		return UpgradePlan{}, fmt.Errorf("launch template %s: %w", plan.LaunchTemplateName, err)
+	}
+	plan.BlockDeviceChanges = prepared.blockDeviceChanges
+
+	asg, err := u.getAsgByName(target.asgName)
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+	if err := u.applyInstanceTypeOverrides(asg); err != nil {
+		return UpgradePlan{}, err
+	}
+	plan.BatchSize, err = u.chooseBatchSize(asg, prepared.data, len(instances))
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+	plan.UserDataDiff = prepared.userDataDiff
+
+	overrideTemplates, err := u.getOverrideLaunchTemplates(asg, target.lt, target.latestImage)
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+	templateIDs := []string{*target.lt.LaunchTemplateId}
+	for _, o := range overrideTemplates {
+		templateIDs = append(templateIDs, *o.lt.LaunchTemplateId)
+	}
+	deletions, err := u.findLaunchTemplateVersionsToDelete(templateIDs, true)
+	if err != nil {
+		return UpgradePlan{}, err
+	}
+	for _, v := range deletions {
+		plan.LaunchTemplateDeletions = append(plan.LaunchTemplateDeletions, LaunchTemplateVersionDeletion{
+			LaunchTemplateName: *v.LaunchTemplateName,
+			VersionNumber:      *v.VersionNumber,
+			CreateTime:         *v.CreateTime,
+		})
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,224 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Severity is how likely a preflight finding is to cause downtime during an upgrade
+type Severity string
+
+const (
+	SeverityHigh   Severity = "high"
+	SeverityMedium Severity = "medium"
+	SeverityLow    Severity = "low"
+)
+
+// PreflightFinding is a risk found in a service or task before an upgrade
+type PreflightFinding struct {
+	Severity Severity
+	Resource string
+	Message  string
+}
+
+// PreflightReport lists the risks of upgrading a cluster. The upgrade process assumes that every service
+// runs more than one task and can place replacement tasks while instances are drained.
+type PreflightReport struct {
+	Cluster  string
+	Findings []PreflightFinding
+}
+
+// HasHighRisk is true if any finding has high severity
+func (r PreflightReport) HasHighRisk() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityHigh {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight inspects the services and tasks in the cluster for anything that would suffer downtime when
+// its instances are replaced
+func (u *Upgrader) Preflight() (PreflightReport, error) {
+	if u.cluster == "" {
+		return PreflightReport{}, fmt.Errorf("cluster name must be set in config to run preflight checks")
+	}
+
+	instances, err := u.getInstanceIDsForCluster(u.cluster)
+	if err != nil {
+		return PreflightReport{}, err
+	}
+
+	serviceArns, err := u.listServiceARNs()
+	if err != nil {
+		return PreflightReport{}, err
+	}
+	services, err := u.describeServices(serviceArns)
+	if err != nil {
+		return PreflightReport{}, err
+	}
+
+	tasks, err := u.listClusterTasks("")
+	if err != nil {
+		return PreflightReport{}, err
+	}
+
+	report := PreflightReport{Cluster: u.cluster}
+	report.Findings = append(report.Findings, evaluateServices(services, len(instances))...)
+	report.Findings = append(report.Findings, evaluateTasks(tasks)...)
+
+	return report, nil
+}
+
+// logPreflight runs the preflight checks and logs the findings. If abortOnHighRisk is enabled, an error is
+// returned when there are high risk findings.
+func (u *Upgrader) logPreflight() error {
+	report, err := u.Preflight()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range report.Findings {
+		u.logger.Printf("Preflight %s risk: %s: %s\n", f.Severity, f.Resource, f.Message)
+	}
+
+	if u.abortOnHighRisk && report.HasHighRisk() {
+		return fmt.Errorf("preflight checks found high risk services or tasks in cluster %s", u.cluster)
+	}
+	return nil
+}
+
+// describeServices describes the given services, 10 at a time
+func (u *Upgrader) describeServices(serviceArns []string) ([]ecsTypes.Service, error) {
+	var services []ecsTypes.Service
+	for start := 0; start < len(serviceArns); start += 10 {
+		end := min(start+10, len(serviceArns))
+		result, err := u.ecsClient.DescribeServices(context.Background(), &ecs.DescribeServicesInput{
+			Cluster:  aws.String(u.cluster),
+			Services: serviceArns[start:end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing services: %s", err)
+		}
+		services = append(services, result.Services...)
+	}
+	return services, nil
+}
+
+// listClusterTasks describes all the running and pending tasks in the cluster, or only those on the given
+// container instance
+func (u *Upgrader) listClusterTasks(containerInstanceArn string) ([]ecsTypes.Task, error) {
+	input := &ecs.ListTasksInput{
+		Cluster:    aws.String(u.cluster),
+		MaxResults: aws.Int32(100),
+	}
+	if containerInstanceArn != "" {
+		input.ContainerInstance = aws.String(containerInstanceArn)
+	}
+
+	var taskArns []string
+	paginator := ecs.NewListTasksPaginator(u.ecsClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("error listing tasks: %s", err)
+		}
+		taskArns = append(taskArns, page.TaskArns...)
+	}
+	return u.describeTasks(taskArns)
+}
+
+// describeTasks describes the tasks in the cluster, 100 at a time
+func (u *Upgrader) describeTasks(taskArns []string) ([]ecsTypes.Task, error) {
+	var tasks []ecsTypes.Task
+	for start := 0; start < len(taskArns); start += 100 {
+		end := min(start+100, len(taskArns))
+		result, err := u.ecsClient.DescribeTasks(context.Background(), &ecs.DescribeTasksInput{
+			Cluster: aws.String(u.cluster),
+			Tasks:   taskArns[start:end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing tasks: %s", err)
+		}
+		tasks = append(tasks, result.Tasks...)
+	}
+	return tasks, nil
+}
+
+// evaluateServices returns findings for services that can't keep running while the given number of
+// container instances are replaced
+func evaluateServices(services []ecsTypes.Service, instanceCount int) []PreflightFinding {
+	var findings []PreflightFinding
+	for _, s := range services {
+		name := "service " + aws.ToString(s.ServiceName)
+		add := func(severity Severity, format string, args ...any) {
+			findings = append(findings, PreflightFinding{Severity: severity, Resource: name, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if s.SchedulingStrategy == ecsTypes.SchedulingStrategyDaemon {
+			add(SeverityLow, "daemon service tasks stop with each old instance and start on each new instance")
+			continue
+		}
+		if s.DesiredCount == 0 {
+			continue
+		}
+
+		minHealthy, maxPercent := int32(100), int32(200)
+		if c := s.DeploymentConfiguration; c != nil && c.MinimumHealthyPercent != nil {
+			minHealthy = *c.MinimumHealthyPercent
+		}
+		if c := s.DeploymentConfiguration; c != nil && c.MaximumPercent != nil {
+			maxPercent = *c.MaximumPercent
+		}
+		canSurge := s.DesiredCount*maxPercent/100 > s.DesiredCount
+
+		if s.DesiredCount == 1 {
+			if minHealthy < 100 {
+				add(SeverityHigh, "runs a single task, which is stopped before its replacement starts (minimumHealthyPercent %d)", minHealthy)
+			} else {
+				add(SeverityMedium, "runs a single task, so any failure of its replacement causes downtime")
+			}
+		}
+
+		if minHealthy >= 100 && !canSurge {
+			add(SeverityHigh, "minimumHealthyPercent %d with maximumPercent %d leaves no room to start replacement tasks, "+
+				"so tasks can't be moved off draining instances", minHealthy, maxPercent)
+		}
+
+		for _, c := range s.PlacementConstraints {
+			if c.Type == ecsTypes.PlacementConstraintTypeDistinctInstance && int(s.DesiredCount) > instanceCount {
+				add(SeverityHigh, "distinctInstance placement needs %d instances but only %d new instances will be available",
+					s.DesiredCount, instanceCount)
+			}
+		}
+	}
+	return findings
+}
+
+// evaluateTasks returns findings for tasks that are not owned by a service and so won't be replaced
+func evaluateTasks(tasks []ecsTypes.Task) []PreflightFinding {
+	var findings []PreflightFinding
+	for _, t := range tasks {
+		if !isStandaloneTask(t) {
+			continue
+		}
+		findings = append(findings, PreflightFinding{
+			Severity: SeverityMedium,
+			Resource: "task " + aws.ToString(t.TaskArn),
+			Message: fmt.Sprintf("standalone task in group %s is stopped with its instance and not replaced",
+				aws.ToString(t.Group)),
+		})
+	}
+	return findings
+}
+
+// isStandaloneTask is true for tasks that were not started by a service
+func isStandaloneTask(t ecsTypes.Task) bool {
+	return !strings.HasPrefix(aws.ToString(t.Group), "service:")
+}
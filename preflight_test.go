@@ -0,0 +1,101 @@
+package ead
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestEvaluateServices(t *testing.T) {
+	deployment := func(minHealthy, maxPercent int32) *ecsTypes.DeploymentConfiguration {
+		return &ecsTypes.DeploymentConfiguration{
+			MinimumHealthyPercent: aws.Int32(minHealthy),
+			MaximumPercent:        aws.Int32(maxPercent),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		service ecsTypes.Service
+		want    []Severity
+	}{
+		{
+			name:    "safe",
+			service: ecsTypes.Service{DesiredCount: 2, DeploymentConfiguration: deployment(50, 200)},
+			want:    nil,
+		},
+		{
+			name:    "default deployment configuration",
+			service: ecsTypes.Service{DesiredCount: 2},
+			want:    nil,
+		},
+		{
+			name:    "scaled to zero",
+			service: ecsTypes.Service{DesiredCount: 0, DeploymentConfiguration: deployment(100, 100)},
+			want:    nil,
+		},
+		{
+			name:    "single task stopped first",
+			service: ecsTypes.Service{DesiredCount: 1, DeploymentConfiguration: deployment(0, 100)},
+			want:    []Severity{SeverityHigh},
+		},
+		{
+			name:    "single task with surge",
+			service: ecsTypes.Service{DesiredCount: 1, DeploymentConfiguration: deployment(100, 200)},
+			want:    []Severity{SeverityMedium},
+		},
+		{
+			name:    "no surge",
+			service: ecsTypes.Service{DesiredCount: 3, DeploymentConfiguration: deployment(100, 130)},
+			want:    []Severity{SeverityHigh},
+		},
+		{
+			name:    "daemon",
+			service: ecsTypes.Service{SchedulingStrategy: ecsTypes.SchedulingStrategyDaemon, DesiredCount: 3},
+			want:    []Severity{SeverityLow},
+		},
+		{
+			name: "distinct instance does not fit",
+			service: ecsTypes.Service{
+				DesiredCount:            4,
+				DeploymentConfiguration: deployment(50, 200),
+				PlacementConstraints: []ecsTypes.PlacementConstraint{
+					{Type: ecsTypes.PlacementConstraintTypeDistinctInstance},
+				},
+			},
+			want: []Severity{SeverityHigh},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.service.ServiceName = aws.String("web")
+			got := evaluateServices([]ecsTypes.Service{tt.service}, 3)
+			if len(got) != len(tt.want) {
+				t.Fatalf("evaluateServices() = %+v, want severities %v", got, tt.want)
+			}
+			for i, f := range got {
+				if f.Severity != tt.want[i] {
+					t.Errorf("finding %d severity is %s, want %s: %s", i, f.Severity, tt.want[i], f.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateTasks(t *testing.T) {
+	tasks := []ecsTypes.Task{
+		{TaskArn: aws.String("arn:task/1"), Group: aws.String("service:web")},
+		{TaskArn: aws.String("arn:task/2"), Group: aws.String("family:migrate")},
+	}
+
+	got := evaluateTasks(tasks)
+	if len(got) != 1 || got[0].Resource != "task arn:task/2" {
+		t.Errorf("evaluateTasks() = %+v, want one finding for task 2", got)
+	}
+
+	report := PreflightReport{Findings: got}
+	if report.HasHighRisk() {
+		t.Error("standalone tasks should not be high risk")
+	}
+}
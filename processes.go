@@ -0,0 +1,140 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// scalingProcesses are the ASG processes suspended during an upgrade, so that scaling and rebalancing
+// don't change the ASG's instances while they are being replaced
+var scalingProcesses = []string{"AZRebalance", "AlarmNotification", "ScheduledActions"}
+
+// replaceUnhealthyProcess is also suspended if SuspendReplaceUnhealthy is enabled
+const replaceUnhealthyProcess = "ReplaceUnhealthy"
+
+// suspendScalingProcesses suspends the scaling processes on the ASG. The processes that were already
+// suspended are recorded in an ASG tag first, so they can be restored afterwards even if this run doesn't
+// finish. If the tag is already present from a run that didn't finish, its record is kept.
+func (u *Upgrader) suspendScalingProcesses(asg *asgTypes.AutoScalingGroup) error {
+	asgName := *asg.AutoScalingGroupName
+
+	var alreadySuspended []string
+	for _, p := range asg.SuspendedProcesses {
+		alreadySuspended = append(alreadySuspended, aws.ToString(p.ProcessName))
+	}
+
+	if _, found := asgTagValue(asg, TagNameSuspendedProcesses); !found {
+		_, err := u.asgClient.CreateOrUpdateTags(context.Background(), &autoscaling.CreateOrUpdateTagsInput{
+			Tags: []asgTypes.Tag{{
+				Key:               aws.String(TagNameSuspendedProcesses),
+				Value:             aws.String(strings.Join(alreadySuspended, ",")),
+				PropagateAtLaunch: aws.Bool(false),
+				ResourceId:        aws.String(asgName),
+				ResourceType:      aws.String("auto-scaling-group"),
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record suspended processes of ASG %s: %w", asgName, err)
+		}
+	}
+
+	suspend := processesToSuspend(u.scalingProcesses(), alreadySuspended)
+	if len(suspend) == 0 {
+		return nil
+	}
+
+	_, err := u.asgClient.SuspendProcesses(context.Background(), &autoscaling.SuspendProcessesInput{
+		AutoScalingGroupName: aws.String(asgName),
+		ScalingProcesses:     suspend,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to suspend processes on ASG %s: %w", asgName, err)
+	}
+	u.logger.Printf("Suspended processes on ASG %s: %s\n", asgName, strings.Join(suspend, ", "))
+
+	return nil
+}
+
+// restoreScalingProcesses resumes the scaling processes that were suspended by this or an earlier run,
+// leaving suspended those that were suspended before, and removes the record of them. Nothing is changed if
+// there is no record.
+func (u *Upgrader) restoreScalingProcesses(asgName string) error {
+	asg, err := u.getAsgByName(asgName)
+	if err != nil {
+		return err
+	}
+
+	recorded, found := asgTagValue(asg, TagNameSuspendedProcesses)
+	if !found {
+		return nil
+	}
+
+	var previouslySuspended []string
+	if recorded != "" {
+		previouslySuspended = strings.Split(recorded, ",")
+	}
+
+	// ReplaceUnhealthy is included even if it's not configured, since an earlier run may have suspended it
+	resume := processesToSuspend(append(slices.Clone(scalingProcesses), replaceUnhealthyProcess), previouslySuspended)
+
+	// an empty list would resume every process, including any that were suspended before
+	if len(resume) > 0 {
+		_, err = u.asgClient.ResumeProcesses(context.Background(), &autoscaling.ResumeProcessesInput{
+			AutoScalingGroupName: aws.String(asgName),
+			ScalingProcesses:     resume,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to resume processes on ASG %s: %w", asgName, err)
+		}
+		u.logger.Printf("Resumed processes on ASG %s: %s\n", asgName, strings.Join(resume, ", "))
+	}
+
+	_, err = u.asgClient.DeleteTags(context.Background(), &autoscaling.DeleteTagsInput{
+		Tags: []asgTypes.Tag{{
+			Key:          aws.String(TagNameSuspendedProcesses),
+			ResourceId:   aws.String(asgName),
+			ResourceType: aws.String("auto-scaling-group"),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove record of suspended processes from ASG %s: %w", asgName, err)
+	}
+
+	return nil
+}
+
+// scalingProcesses returns the processes to suspend during an upgrade
+func (u *Upgrader) scalingProcesses() []string {
+	processes := slices.Clone(scalingProcesses)
+	if u.suspendReplaceUnhealthy {
+		processes = append(processes, replaceUnhealthyProcess)
+	}
+	return processes
+}
+
+// processesToSuspend returns the processes that are not already suspended
+func processesToSuspend(processes, alreadySuspended []string) []string {
+	var suspend []string
+	for _, p := range processes {
+		if !slices.Contains(alreadySuspended, p) {
+			suspend = append(suspend, p)
+		}
+	}
+	return suspend
+}
+
+// asgTagValue returns the value of the ASG's tag with the given key, and whether the tag was found
+func asgTagValue(asg *asgTypes.AutoScalingGroup, key string) (string, bool) {
+	for _, t := range asg.Tags {
+		if aws.ToString(t.Key) == key {
+			return aws.ToString(t.Value), true
+		}
+	}
+	return "", false
+}
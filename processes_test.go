@@ -0,0 +1,51 @@
+package ead
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+func TestProcessesToSuspend(t *testing.T) {
+	got := processesToSuspend([]string{"AZRebalance", "AlarmNotification", "ScheduledActions"}, []string{"AlarmNotification", "Launch"})
+	if want := []string{"AZRebalance", "ScheduledActions"}; !slices.Equal(got, want) {
+		t.Errorf("processesToSuspend() = %v, want %v", got, want)
+	}
+
+	if got := processesToSuspend([]string{"AZRebalance"}, []string{"AZRebalance"}); len(got) != 0 {
+		t.Errorf("processesToSuspend() = %v, want none", got)
+	}
+}
+
+func TestUpgrader_scalingProcesses(t *testing.T) {
+	u := Upgrader{}
+	if slices.Contains(u.scalingProcesses(), replaceUnhealthyProcess) {
+		t.Error("ReplaceUnhealthy should only be suspended when configured")
+	}
+
+	u.suspendReplaceUnhealthy = true
+	if !slices.Contains(u.scalingProcesses(), replaceUnhealthyProcess) {
+		t.Error("ReplaceUnhealthy should be suspended when configured")
+	}
+	if len(scalingProcesses) != 3 {
+		t.Error("scalingProcesses was modified")
+	}
+}
+
+func TestAsgTagValue(t *testing.T) {
+	asg := &asgTypes.AutoScalingGroup{
+		Tags: []asgTypes.TagDescription{
+			{Key: aws.String("Name"), Value: aws.String("ecs-prod")},
+			{Key: aws.String(TagNameSuspendedProcesses), Value: aws.String("")},
+		},
+	}
+
+	if v, found := asgTagValue(asg, TagNameSuspendedProcesses); !found || v != "" {
+		t.Errorf("asgTagValue() = %q, %t, want empty value found", v, found)
+	}
+	if _, found := asgTagValue(asg, "missing"); found {
+		t.Error("asgTagValue() found a missing tag")
+	}
+}
@@ -0,0 +1,173 @@
+package ead
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	provenancePrefix          = "ecs-ami-deploy"
+	maxVersionDescriptionSize = 255
+)
+
+// Provenance records which run of ecs-ami-deploy created a launch template version and which AMIs it
+// upgraded from and to. It is stored in the version description.
+type Provenance struct {
+	ToolVersion   string
+	RunID         string
+	FromImageID   string
+	FromImageName string
+	ToImageID     string
+	ToImageName   string
+}
+
+// LaunchTemplateHistoryEntry is one version of a cluster's launch template. Provenance is nil for versions
+// that were not created by ecs-ami-deploy.
+type LaunchTemplateHistoryEntry struct {
+	LaunchTemplateName string
+	VersionNumber      int64
+	CreateTime         time.Time
+	CreatedBy          string
+	ImageID            string
+	Description        string
+	Provenance         *Provenance
+}
+
+// newRunID returns a random ID that identifies one run of the tool across all the changes it makes
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// provenance returns the provenance of a launch template version created by this Upgrader
+func (u *Upgrader) provenance(from, to ec2types.Image) Provenance {
+	return Provenance{
+		ToolVersion:   u.toolVersion,
+		RunID:         u.runID,
+		FromImageID:   aws.ToString(from.ImageId),
+		FromImageName: aws.ToString(from.Name),
+		ToImageID:     aws.ToString(to.ImageId),
+		ToImageName:   aws.ToString(to.Name),
+	}
+}
+
+// Description formats the provenance as a launch template version description, for example:
+//
+//	ecs-ami-deploy|version=3.1.0|run=9f86d081884c7d65|from=ami-0a1|from-name=al2023-ami-ecs-hvm-...|to=ami-0b2|to-name=...
+//
+// Image names are shortened if needed to fit the 255 character limit on descriptions.
+func (p Provenance) Description() string {
+	format := func(fromName, toName string) string {
+		return strings.Join([]string{
+			provenancePrefix,
+			"version=" + p.ToolVersion,
+			"run=" + p.RunID,
+			"from=" + p.FromImageID,
+			"from-name=" + fromName,
+			"to=" + p.ToImageID,
+			"to-name=" + toName,
+		}, "|")
+	}
+
+	fromName, toName := p.FromImageName, p.ToImageName
+	desc := format(fromName, toName)
+	if over := len(desc) - maxVersionDescriptionSize; over > 0 {
+		cut := min(over, len(fromName))
+		fromName = fromName[:len(fromName)-cut]
+		toName = toName[:max(len(toName)-(over-cut), 0)]
+		desc = format(fromName, toName)
+	}
+
+	return desc[:min(len(desc), maxVersionDescriptionSize)]
+}
+
+// ParseProvenance reads the provenance from a launch template version description. It returns false if
+// the description was not written by ecs-ami-deploy.
+func ParseProvenance(description string) (Provenance, bool) {
+	fields := strings.Split(description, "|")
+	if len(fields) == 0 || fields[0] != provenancePrefix {
+		return Provenance{}, false
+	}
+
+	var p Provenance
+	for _, f := range fields[1:] {
+		key, value, _ := strings.Cut(f, "=")
+		switch key {
+		case "version":
+			p.ToolVersion = value
+		case "run":
+			p.RunID = value
+		case "from":
+			p.FromImageID = value
+		case "from-name":
+			p.FromImageName = value
+		case "to":
+			p.ToImageID = value
+		case "to-name":
+			p.ToImageName = value
+		}
+	}
+
+	return p, true
+}
+
+// History lists the versions of the launch template used by the cluster's ASG, oldest first, along with
+// the provenance of the versions created by ecs-ami-deploy
+func (u *Upgrader) History() ([]LaunchTemplateHistoryEntry, error) {
+	if u.cluster == "" {
+		return nil, fmt.Errorf("cluster name must be set in config to list launch template history")
+	}
+
+	asgName, err := u.getAsgNameForCluster(u.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	lt, _, err := u.getLaunchTemplateForASG(asgName)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := u.getTemplateVersions([]ec2types.LaunchTemplate{*lt})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get launch template versions: %w", err)
+	}
+
+	return launchTemplateHistory(versions), nil
+}
+
+// launchTemplateHistory converts launch template versions into history entries, oldest first
+func launchTemplateHistory(versions []ec2types.LaunchTemplateVersion) []LaunchTemplateHistoryEntry {
+	history := make([]LaunchTemplateHistoryEntry, len(versions))
+	for i, v := range versions {
+		history[i] = LaunchTemplateHistoryEntry{
+			LaunchTemplateName: aws.ToString(v.LaunchTemplateName),
+			VersionNumber:      aws.ToInt64(v.VersionNumber),
+			CreateTime:         aws.ToTime(v.CreateTime),
+			CreatedBy:          aws.ToString(v.CreatedBy),
+			Description:        aws.ToString(v.VersionDescription),
+		}
+		if v.LaunchTemplateData != nil {
+			history[i].ImageID = aws.ToString(v.LaunchTemplateData.ImageId)
+		}
+		if p, ok := ParseProvenance(history[i].Description); ok {
+			history[i].Provenance = &p
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].VersionNumber < history[j].VersionNumber
+	})
+
+	return history
+}
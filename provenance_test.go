@@ -0,0 +1,79 @@
+package ead
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestProvenance_Description(t *testing.T) {
+	p := Provenance{
+		ToolVersion:   "3.1.0",
+		RunID:         "9f86d081884c7d65",
+		FromImageID:   "ami-0a1",
+		FromImageName: "al2023-ami-ecs-hvm-2023.0.20240101-kernel-6.1-x86_64",
+		ToImageID:     "ami-0b2",
+		ToImageName:   "al2023-ami-ecs-hvm-2023.0.20240201-kernel-6.1-x86_64",
+	}
+
+	desc := p.Description()
+	got, ok := ParseProvenance(desc)
+	if !ok {
+		t.Fatalf("ParseProvenance(%q) did not recognize the description", desc)
+	}
+	if got != p {
+		t.Errorf("ParseProvenance() = %+v, want %+v", got, p)
+	}
+
+	p.FromImageName = strings.Repeat("a", 128)
+	p.ToImageName = strings.Repeat("b", 128)
+	desc = p.Description()
+	if len(desc) > maxVersionDescriptionSize {
+		t.Errorf("description is %d characters, want at most %d", len(desc), maxVersionDescriptionSize)
+	}
+	got, ok = ParseProvenance(desc)
+	if !ok || got.ToImageID != p.ToImageID || got.RunID != p.RunID {
+		t.Errorf("ParseProvenance() of shortened description = %+v", got)
+	}
+
+	if _, ok := ParseProvenance("created by hand"); ok {
+		t.Error("ParseProvenance() recognized a description that was not written by ecs-ami-deploy")
+	}
+}
+
+func TestLaunchTemplateHistory(t *testing.T) {
+	created := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	versions := []ec2types.LaunchTemplateVersion{
+		{
+			LaunchTemplateName: aws.String("ecs-prod"),
+			VersionNumber:      aws.Int64(2),
+			CreateTime:         aws.Time(created),
+			VersionDescription: aws.String("ecs-ami-deploy|version=3.1.0|run=abc|from=ami-1|to=ami-2"),
+			LaunchTemplateData: &ec2types.ResponseLaunchTemplateData{ImageId: aws.String("ami-2")},
+		},
+		{
+			LaunchTemplateName: aws.String("ecs-prod"),
+			VersionNumber:      aws.Int64(1),
+			CreateTime:         aws.Time(created.AddDate(0, -1, 0)),
+			LaunchTemplateData: &ec2types.ResponseLaunchTemplateData{ImageId: aws.String("ami-1")},
+		},
+	}
+
+	history := launchTemplateHistory(versions)
+
+	if len(history) != 2 || history[0].VersionNumber != 1 || history[1].VersionNumber != 2 {
+		t.Fatalf("history is not sorted by version: %+v", history)
+	}
+	if history[0].Provenance != nil {
+		t.Error("version 1 should have no provenance")
+	}
+	if p := history[1].Provenance; p == nil || p.FromImageID != "ami-1" || p.RunID != "abc" {
+		t.Errorf("version 2 provenance = %+v", p)
+	}
+	if history[1].ImageID != "ami-2" {
+		t.Errorf("version 2 image is %s, want ami-2", history[1].ImageID)
+	}
+}
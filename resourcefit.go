@@ -0,0 +1,324 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+)
+
+// taskRequirement is what a task needs from the container instance it is placed on
+type taskRequirement struct {
+	task     string
+	cpu      int32
+	memory   int32
+	gpus     int32
+	tcpPorts []string
+	udpPorts []string
+}
+
+func (r taskRequirement) String() string {
+	s := fmt.Sprintf("%s (%d CPU units, %d MiB", r.task, r.cpu, r.memory)
+	if r.gpus > 0 {
+		s += fmt.Sprintf(", %d GPUs", r.gpus)
+	}
+	for _, p := range r.tcpPorts {
+		s += ", port " + p
+	}
+	for _, p := range r.udpPorts {
+		s += ", port " + p + "/udp"
+	}
+	return s + ")"
+}
+
+// instanceCapacity is what a container instance has left for more tasks
+type instanceCapacity struct {
+	instance string
+	cpu      int32
+	memory   int32
+	gpus     int32
+	tcpPorts []string
+	udpPorts []string
+}
+
+// fits returns true if the task can be placed on the instance
+func (c instanceCapacity) fits(r taskRequirement) bool {
+	if r.cpu > c.cpu || r.memory > c.memory || r.gpus > c.gpus {
+		return false
+	}
+	for _, p := range r.tcpPorts {
+		if internal.IsStringInSlice(p, c.tcpPorts) {
+			return false
+		}
+	}
+	for _, p := range r.udpPorts {
+		if internal.IsStringInSlice(p, c.udpPorts) {
+			return false
+		}
+	}
+	return true
+}
+
+// place takes the task's resources from the instance
+func (c *instanceCapacity) place(r taskRequirement) {
+	c.cpu -= r.cpu
+	c.memory -= r.memory
+	c.gpus -= r.gpus
+	c.tcpPorts = append(c.tcpPorts, r.tcpPorts...)
+	c.udpPorts = append(c.udpPorts, r.udpPorts...)
+}
+
+// fitTasks places the tasks, largest memory first, on the first instance each fits on, and returns the tasks
+// that don't fit anywhere. The instances are not modified.
+func fitTasks(tasks []taskRequirement, instances []instanceCapacity) []taskRequirement {
+	remaining := make([]instanceCapacity, len(instances))
+	for i, c := range instances {
+		c.tcpPorts = append([]string{}, c.tcpPorts...)
+		c.udpPorts = append([]string{}, c.udpPorts...)
+		remaining[i] = c
+	}
+
+	sorted := append([]taskRequirement{}, tasks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].memory != sorted[j].memory {
+			return sorted[i].memory > sorted[j].memory
+		}
+		return sorted[i].cpu > sorted[j].cpu
+	})
+
+	var unplaced []taskRequirement
+	for _, t := range sorted {
+		placed := false
+		for i := range remaining {
+			if remaining[i].fits(t) {
+				remaining[i].place(t)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			unplaced = append(unplaced, t)
+		}
+	}
+	return unplaced
+}
+
+// taskDefinitionRequirement returns the resources a task of the task definition reserves. Task level CPU and
+// memory are used if they are set, otherwise the containers' values are added up.
+func taskDefinitionRequirement(task string, td ecsTypes.TaskDefinition) taskRequirement {
+	r := taskRequirement{task: task}
+
+	var containerCPU, containerMemory int32
+	for _, c := range td.ContainerDefinitions {
+		containerCPU += c.Cpu
+		if c.Memory != nil {
+			containerMemory += *c.Memory
+		} else {
+			containerMemory += aws.ToInt32(c.MemoryReservation)
+		}
+
+		for _, rr := range c.ResourceRequirements {
+			if rr.Type == ecsTypes.ResourceTypeGpu {
+				n, _ := strconv.Atoi(aws.ToString(rr.Value))
+				r.gpus += int32(n)
+			}
+		}
+
+		for _, pm := range c.PortMappings {
+			port := int32(0)
+			switch td.NetworkMode {
+			case ecsTypes.NetworkModeHost:
+				port = aws.ToInt32(pm.ContainerPort)
+			case ecsTypes.NetworkModeBridge, "":
+				// a host port of zero is assigned from the ephemeral range
+				port = aws.ToInt32(pm.HostPort)
+			}
+			if port == 0 {
+				continue
+			}
+			if pm.Protocol == ecsTypes.TransportProtocolUdp {
+				r.udpPorts = append(r.udpPorts, strconv.Itoa(int(port)))
+			} else {
+				r.tcpPorts = append(r.tcpPorts, strconv.Itoa(int(port)))
+			}
+		}
+	}
+
+	r.cpu = containerCPU
+	if cpu := parseTaskSize(aws.ToString(td.Cpu), "vCPU"); cpu > 0 {
+		r.cpu = cpu
+	}
+	r.memory = containerMemory
+	if memory := parseTaskSize(aws.ToString(td.Memory), "GB"); memory > 0 {
+		r.memory = memory
+	}
+	return r
+}
+
+// parseTaskSize parses a task level CPU or memory size, such as "512", "1 vCPU" or "2GB", into CPU units or
+// MiB. It returns zero if the size isn't set or can't be parsed.
+func parseTaskSize(size, unit string) int32 {
+	size = strings.TrimSpace(size)
+	multiplier := 1.0
+	if s, found := strings.CutSuffix(size, unit); found {
+		size = strings.TrimSpace(s)
+		multiplier = 1024
+	}
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0
+	}
+	return int32(n * multiplier)
+}
+
+// containerInstanceCapacity returns the remaining resources of the container instance
+func containerInstanceCapacity(ci ecsTypes.ContainerInstance) instanceCapacity {
+	c := instanceCapacity{instance: aws.ToString(ci.Ec2InstanceId)}
+	for _, r := range ci.RemainingResources {
+		switch aws.ToString(r.Name) {
+		case "CPU":
+			c.cpu = r.IntegerValue
+		case "MEMORY":
+			c.memory = r.IntegerValue
+		case "GPU":
+			c.gpus = int32(len(r.StringSetValue))
+		case "PORTS":
+			c.tcpPorts = r.StringSetValue
+		case "PORTS_UDP":
+			c.udpPorts = r.StringSetValue
+		}
+	}
+	return c
+}
+
+// waitForResourceFit waits until the tasks that would move off the container instance fit on the cluster's
+// other active container instances, leaving out the instances that are about to be retired as well. Once the
+// resource fit timeout is reached, it returns an error explaining what doesn't fit. Standalone tasks and tasks
+// of daemon services are not moved, so they are not counted.
+func (u *Upgrader) waitForResourceFit(instanceID, containerInstanceArn string, retiring []string) error {
+	startTime := time.Now()
+	for {
+		problem, err := u.checkResourceFit(containerInstanceArn, retiring)
+		if err != nil {
+			return err
+		}
+		if problem == "" {
+			return nil
+		}
+
+		if time.Since(startTime) >= u.resourceFitTimeout {
+			return fmt.Errorf("not deregistering instance %s because its tasks don't fit on the rest of the "+
+				"cluster: %s", instanceID, problem)
+		}
+		u.logger.Printf("Waiting for capacity to move the tasks on instance %s: %s", instanceID, problem)
+		if err := u.pause(u.pollingInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// checkResourceFit returns an explanation if the tasks on the container instance don't fit on the cluster's
+// other active container instances that aren't retiring, or an empty string if they do
+func (u *Upgrader) checkResourceFit(containerInstanceArn string, retiring []string) (string, error) {
+	tasks, err := u.listClusterTasks(containerInstanceArn)
+	if err != nil {
+		return "", err
+	}
+
+	serviceArns, err := u.listServiceARNs()
+	if err != nil {
+		return "", err
+	}
+	services, err := u.describeServices(serviceArns)
+	if err != nil {
+		return "", err
+	}
+	daemons := map[string]bool{}
+	for _, s := range services {
+		if s.SchedulingStrategy == ecsTypes.SchedulingStrategyDaemon {
+			daemons["service:"+aws.ToString(s.ServiceName)] = true
+		}
+	}
+
+	taskDefinitions := map[string]ecsTypes.TaskDefinition{}
+	var requirements []taskRequirement
+	for _, t := range tasks {
+		if isStandaloneTask(t) || daemons[aws.ToString(t.Group)] {
+			continue
+		}
+
+		arn := aws.ToString(t.TaskDefinitionArn)
+		td, ok := taskDefinitions[arn]
+		if !ok {
+			result, err := u.ecsClient.DescribeTaskDefinition(context.Background(), &ecs.DescribeTaskDefinitionInput{
+				TaskDefinition: aws.String(arn),
+			})
+			if err != nil {
+				return "", fmt.Errorf("error describing task definition %s: %w", arn, err)
+			}
+			td = *result.TaskDefinition
+			taskDefinitions[arn] = td
+		}
+		requirements = append(requirements, taskDefinitionRequirement(aws.ToString(t.TaskArn), td))
+	}
+	if len(requirements) == 0 {
+		return "", nil
+	}
+
+	containerInstances, err := u.getInstanceListForCluster(u.cluster)
+	if err != nil {
+		return "", err
+	}
+	var capacity []instanceCapacity
+	for _, ci := range containerInstances {
+		arn := aws.ToString(ci.ContainerInstanceArn)
+		if arn == containerInstanceArn || internal.IsStringInSlice(arn, retiring) ||
+			aws.ToString(ci.Status) != "ACTIVE" || !ci.AgentConnected {
+			continue
+		}
+		capacity = append(capacity, containerInstanceCapacity(ci))
+	}
+
+	unplaced := fitTasks(requirements, capacity)
+	if len(unplaced) == 0 {
+		return "", nil
+	}
+	descriptions := make([]string, len(unplaced))
+	for i, r := range unplaced {
+		descriptions[i] = r.String()
+	}
+	return fmt.Sprintf("%d of %d tasks can't be placed on the %d other active container instances: %s%s",
+		len(unplaced), len(requirements), len(capacity), strings.Join(descriptions, ", "), largestCapacity(capacity)), nil
+}
+
+// largestCapacity describes the instance with the most free memory, to explain why tasks don't fit
+func largestCapacity(instances []instanceCapacity) string {
+	if len(instances) == 0 {
+		return ""
+	}
+	largest := instances[0]
+	for _, c := range instances[1:] {
+		if c.memory > largest.memory || (c.memory == largest.memory && c.cpu > largest.cpu) {
+			largest = c
+		}
+	}
+	return fmt.Sprintf("; the most free memory is on %s with %d CPU units and %d MiB",
+		largest.instance, largest.cpu, largest.memory)
+}
+
+// containerInstanceArns returns the ARNs of the container instances
+func containerInstanceArns(instances []ecsTypes.ContainerInstance) []string {
+	arns := make([]string, len(instances))
+	for i, ci := range instances {
+		arns[i] = aws.ToString(ci.ContainerInstanceArn)
+	}
+	return arns
+}
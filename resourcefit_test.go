@@ -0,0 +1,158 @@
+package ead
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestTaskDefinitionRequirement(t *testing.T) {
+	bridge := ecsTypes.TaskDefinition{
+		ContainerDefinitions: []ecsTypes.ContainerDefinition{
+			{
+				Cpu:    256,
+				Memory: aws.Int32(512),
+				PortMappings: []ecsTypes.PortMapping{
+					{ContainerPort: aws.Int32(80), HostPort: aws.Int32(8080)},
+					{ContainerPort: aws.Int32(9000), HostPort: aws.Int32(0)},
+					{ContainerPort: aws.Int32(53), HostPort: aws.Int32(53), Protocol: ecsTypes.TransportProtocolUdp},
+				},
+			},
+			{
+				Cpu:               128,
+				MemoryReservation: aws.Int32(256),
+				ResourceRequirements: []ecsTypes.ResourceRequirement{
+					{Type: ecsTypes.ResourceTypeGpu, Value: aws.String("1")},
+				},
+			},
+		},
+	}
+	got := taskDefinitionRequirement("web", bridge)
+	if got.cpu != 384 || got.memory != 768 || got.gpus != 1 {
+		t.Errorf("got %d CPU, %d MiB, %d GPUs, want 384, 768, 1", got.cpu, got.memory, got.gpus)
+	}
+	if fmt.Sprint(got.tcpPorts) != "[8080]" || fmt.Sprint(got.udpPorts) != "[53]" {
+		t.Errorf("got ports %v and %v/udp, want [8080] and [53]/udp", got.tcpPorts, got.udpPorts)
+	}
+
+	host := ecsTypes.TaskDefinition{
+		Cpu:         aws.String("1 vCPU"),
+		Memory:      aws.String("2GB"),
+		NetworkMode: ecsTypes.NetworkModeHost,
+		ContainerDefinitions: []ecsTypes.ContainerDefinition{
+			{Cpu: 256, Memory: aws.Int32(512), PortMappings: []ecsTypes.PortMapping{{ContainerPort: aws.Int32(443)}}},
+		},
+	}
+	got = taskDefinitionRequirement("api", host)
+	if got.cpu != 1024 || got.memory != 2048 || fmt.Sprint(got.tcpPorts) != "[443]" {
+		t.Errorf("got %d CPU, %d MiB, ports %v, want 1024, 2048, [443]", got.cpu, got.memory, got.tcpPorts)
+	}
+
+	awsvpc := host
+	awsvpc.NetworkMode = ecsTypes.NetworkModeAwsvpc
+	if got = taskDefinitionRequirement("api", awsvpc); len(got.tcpPorts) != 0 {
+		t.Errorf("awsvpc task should not reserve host ports, got %v", got.tcpPorts)
+	}
+}
+
+func TestParseTaskSize(t *testing.T) {
+	tests := []struct {
+		size string
+		unit string
+		want int32
+	}{
+		{"512", "vCPU", 512},
+		{"0.5 vCPU", "vCPU", 512},
+		{"2GB", "GB", 2048},
+		{"3072", "GB", 3072},
+		{"", "GB", 0},
+		{"lots", "GB", 0},
+	}
+	for _, tt := range tests {
+		if got := parseTaskSize(tt.size, tt.unit); got != tt.want {
+			t.Errorf("parseTaskSize(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestContainerInstanceCapacity(t *testing.T) {
+	ci := ecsTypes.ContainerInstance{
+		Ec2InstanceId: aws.String("i-1"),
+		RemainingResources: []ecsTypes.Resource{
+			{Name: aws.String("CPU"), IntegerValue: 1024},
+			{Name: aws.String("MEMORY"), IntegerValue: 3000},
+			{Name: aws.String("GPU"), StringSetValue: []string{"gpu-a", "gpu-b"}},
+			{Name: aws.String("PORTS"), StringSetValue: []string{"22", "8080"}},
+			{Name: aws.String("PORTS_UDP"), StringSetValue: []string{"53"}},
+		},
+	}
+	got := containerInstanceCapacity(ci)
+	if got.instance != "i-1" || got.cpu != 1024 || got.memory != 3000 || got.gpus != 2 ||
+		len(got.tcpPorts) != 2 || len(got.udpPorts) != 1 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestFitTasks(t *testing.T) {
+	instances := []instanceCapacity{
+		{instance: "i-1", cpu: 1024, memory: 2048, tcpPorts: []string{"22"}},
+		{instance: "i-2", cpu: 2048, memory: 4096, gpus: 1, tcpPorts: []string{"22", "8080"}},
+	}
+
+	tests := []struct {
+		name  string
+		tasks []taskRequirement
+		want  []string
+	}{
+		{
+			name: "all fit",
+			tasks: []taskRequirement{
+				{task: "a", cpu: 512, memory: 1024},
+				{task: "b", cpu: 1024, memory: 3072},
+				{task: "c", cpu: 512, memory: 1024},
+			},
+		},
+		{
+			name: "too much memory",
+			tasks: []taskRequirement{
+				{task: "a", cpu: 256, memory: 4096},
+				{task: "b", cpu: 256, memory: 4096},
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "port taken",
+			tasks: []taskRequirement{
+				{task: "a", cpu: 256, memory: 256, tcpPorts: []string{"8080"}},
+				{task: "b", cpu: 256, memory: 256, tcpPorts: []string{"8080"}},
+				{task: "c", cpu: 256, memory: 256, tcpPorts: []string{"22"}},
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "gpu",
+			tasks: []taskRequirement{
+				{task: "a", cpu: 256, memory: 256, gpus: 1},
+				{task: "b", cpu: 256, memory: 256, gpus: 1},
+			},
+			want: []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range fitTasks(tt.tasks, instances) {
+				got = append(got, r.task)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("unplaced %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if instances[0].memory != 2048 || len(instances[1].tcpPorts) != 2 {
+		t.Error("fitTasks modified the instances")
+	}
+}
@@ -0,0 +1,172 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// LaunchTemplateVersionDeletion identifies a launch template version removed by the retention settings
+type LaunchTemplateVersionDeletion struct {
+	LaunchTemplateName string
+	VersionNumber      int64
+	CreateTime         time.Time
+}
+
+// findLaunchTemplateVersionsToDelete applies the retention settings to each of the given launch templates
+// separately, so that one template's versions never count against another's limit. Only the templates the
+// cluster's ASG uses are given, so templates of other clusters that share the name prefix are left alone.
+// Versions referenced by any ASG, and each template's default version, are never selected. If pending is set,
+// the templates are treated as if they already had one more version, which is what an upgrade will create.
+func (u *Upgrader) findLaunchTemplateVersionsToDelete(templateIDs []string, pending bool) ([]ec2types.LaunchTemplateVersion, error) {
+	if len(templateIDs) == 0 {
+		return nil, nil
+	}
+
+	result, err := u.ec2Client.DescribeLaunchTemplates(context.Background(), &ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateIds: templateIDs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing launch templates %s: %s", strings.Join(templateIDs, ", "), err)
+	}
+	relevantTemplates := result.LaunchTemplates
+
+	if len(relevantTemplates) == 0 {
+		return nil, nil
+	}
+
+	inUse, err := u.launchTemplateVersionsInUse(relevantTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	var toDelete []ec2types.LaunchTemplateVersion
+	for _, lt := range relevantTemplates {
+		versions, err := u.getTemplateVersions([]ec2types.LaunchTemplate{lt})
+		if err != nil {
+			return nil, err
+		}
+
+		limit := u.launchTemplateLimit
+		if pending && limit > 0 {
+			limit--
+		}
+
+		expired := selectLaunchTemplateVersionsToDelete(versions, inUse[*lt.LaunchTemplateId], limit,
+			u.launchTemplateRetentionAge, time.Now())
+		if len(expired) > 0 {
+			u.logger.Printf("Launch template %s has %v versions. Configured to keep %v, %v of the oldest can be deleted",
+				*lt.LaunchTemplateName, len(versions), u.launchTemplateLimit, len(expired))
+		}
+		toDelete = append(toDelete, expired...)
+	}
+
+	return toDelete, nil
+}
+
+// selectLaunchTemplateVersionsToDelete returns the versions of a single launch template that are beyond
+// the newest limit versions. Versions that are in use, that are the template's default, or that are younger
+// than retentionAge (if not zero) are kept regardless of the limit.
+func selectLaunchTemplateVersionsToDelete(versions []ec2types.LaunchTemplateVersion, inUse map[int64]bool,
+	limit int, retentionAge time.Duration, now time.Time) []ec2types.LaunchTemplateVersion {
+
+	sorted := make([]ec2types.LaunchTemplateVersion, len(versions))
+	copy(sorted, versions)
+
+	// sort launch template versions newest to oldest
+	reverseSortLaunchTemplateVersions(sorted)
+
+	var toDelete []ec2types.LaunchTemplateVersion
+	for i := limit; i < len(sorted); i++ {
+		v := sorted[i]
+		if inUse[*v.VersionNumber] || aws.ToBool(v.DefaultVersion) {
+			continue
+		}
+		if retentionAge > 0 && now.Sub(*v.CreateTime) < retentionAge {
+			continue
+		}
+		toDelete = append(toDelete, v)
+	}
+
+	return toDelete
+}
+
+// launchTemplateVersionsInUse finds the versions of the given templates referenced by any ASG in the region,
+// whether directly or through a mixed instances policy. The result is keyed by launch template ID, then by
+// version number.
+func (u *Upgrader) launchTemplateVersionsInUse(templates []ec2types.LaunchTemplate) (map[string]map[int64]bool, error) {
+	byID := make(map[string]ec2types.LaunchTemplate, len(templates))
+	byName := make(map[string]ec2types.LaunchTemplate, len(templates))
+	for _, lt := range templates {
+		byID[*lt.LaunchTemplateId] = lt
+		byName[*lt.LaunchTemplateName] = lt
+	}
+
+	inUse := make(map[string]map[int64]bool, len(templates))
+	markInUse := func(spec *asgTypes.LaunchTemplateSpecification) {
+		if spec == nil {
+			return
+		}
+		lt, ok := byID[aws.ToString(spec.LaunchTemplateId)]
+		if !ok {
+			if lt, ok = byName[aws.ToString(spec.LaunchTemplateName)]; !ok {
+				return
+			}
+		}
+		version, ok := resolveLaunchTemplateVersion(lt, aws.ToString(spec.Version))
+		if !ok {
+			return
+		}
+		if inUse[*lt.LaunchTemplateId] == nil {
+			inUse[*lt.LaunchTemplateId] = map[int64]bool{}
+		}
+		inUse[*lt.LaunchTemplateId][version] = true
+	}
+
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(u.asgClient, &autoscaling.DescribeAutoScalingGroupsInput{
+		MaxRecords: aws.Int32(100),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving page of auto-scaling groups: %s", err)
+		}
+		for _, g := range page.AutoScalingGroups {
+			markInUse(g.LaunchTemplate)
+			if g.MixedInstancesPolicy == nil || g.MixedInstancesPolicy.LaunchTemplate == nil {
+				continue
+			}
+			markInUse(g.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification)
+			for _, o := range g.MixedInstancesPolicy.LaunchTemplate.Overrides {
+				markInUse(o.LaunchTemplateSpecification)
+			}
+		}
+	}
+
+	return inUse, nil
+}
+
+// resolveLaunchTemplateVersion converts an ASG's launch template version reference, which may be
+// "$Latest", "$Default" (or empty), or a version number, into a version number
+func resolveLaunchTemplateVersion(lt ec2types.LaunchTemplate, version string) (int64, bool) {
+	switch version {
+	case "$Latest":
+		return aws.ToInt64(lt.LatestVersionNumber), true
+	case "$Default", "":
+		return aws.ToInt64(lt.DefaultVersionNumber), true
+	}
+
+	n, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
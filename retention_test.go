@@ -0,0 +1,90 @@
+package ead
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSelectLaunchTemplateVersionsToDelete(t *testing.T) {
+	now := time.Now()
+	oneDay := 24 * time.Hour
+	version := func(n int64, age time.Duration, isDefault bool) ec2types.LaunchTemplateVersion {
+		return ec2types.LaunchTemplateVersion{
+			LaunchTemplateName: aws.String("ecs-prod"),
+			VersionNumber:      aws.Int64(n),
+			CreateTime:         aws.Time(now.Add(-age)),
+			DefaultVersion:     aws.Bool(isDefault),
+		}
+	}
+	versions := []ec2types.LaunchTemplateVersion{
+		version(1, 50*oneDay, false),
+		version(2, 40*oneDay, true),
+		version(3, 30*oneDay, false),
+		version(4, 20*oneDay, false),
+		version(5, 10*oneDay, false),
+		version(6, 1*oneDay, false),
+	}
+
+	tests := []struct {
+		name         string
+		inUse        map[int64]bool
+		limit        int
+		retentionAge time.Duration
+		want         []int64
+	}{
+		{
+			name:  "keeps newest and default",
+			limit: 2,
+			want:  []int64{4, 3, 1},
+		},
+		{
+			name:  "keeps versions in use",
+			inUse: map[int64]bool{3: true},
+			limit: 2,
+			want:  []int64{4, 1},
+		},
+		{
+			name:         "keeps versions younger than retention age",
+			limit:        2,
+			retentionAge: 25 * oneDay,
+			want:         []int64{3, 1},
+		},
+		{
+			name:  "nothing beyond limit",
+			limit: 10,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectLaunchTemplateVersionsToDelete(versions, tt.inUse, tt.limit, tt.retentionAge, now)
+			var gotNumbers []int64
+			for _, v := range got {
+				gotNumbers = append(gotNumbers, *v.VersionNumber)
+			}
+			if fmt.Sprint(gotNumbers) != fmt.Sprint(tt.want) {
+				t.Errorf("got versions %v, want %v", gotNumbers, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveLaunchTemplateVersion(t *testing.T) {
+	lt := ec2types.LaunchTemplate{
+		DefaultVersionNumber: aws.Int64(3),
+		LatestVersionNumber:  aws.Int64(7),
+	}
+	for version, want := range map[string]int64{"$Latest": 7, "$Default": 3, "": 3, "5": 5} {
+		got, ok := resolveLaunchTemplateVersion(lt, version)
+		if !ok || got != want {
+			t.Errorf("resolveLaunchTemplateVersion(%q) = %d, %t, want %d", version, got, ok, want)
+		}
+	}
+	if _, ok := resolveLaunchTemplateVersion(lt, "bogus"); ok {
+		t.Error("expected invalid version to be rejected")
+	}
+}
@@ -0,0 +1,97 @@
+package ead
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// CallLimiter spaces out AWS API calls to stay within a calls-per-second budget. A limiter can be shared by
+// several upgraders, as it is by the upgraders of a Fleet, so that together they stay within the budget.
+type CallLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewCallLimiter returns a CallLimiter that allows the given number of calls per second
+func NewCallLimiter(callsPerSecond float64) *CallLimiter {
+	return &CallLimiter{interval: time.Duration(float64(time.Second) / callsPerSecond)}
+}
+
+// Wait blocks until the next call is allowed, or the context is done
+func (l *CallLimiter) Wait(ctx context.Context) error {
+	wait := l.reserve(time.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes the next free call slot and returns how long to wait for it
+func (l *CallLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return wait
+}
+
+// addMiddleware makes every attempt of an API call, including retries, wait for the limiter
+func (l *CallLimiter) addMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("ecs-ami-deploy-call-limiter",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+			middleware.FinalizeOutput, middleware.Metadata, error) {
+
+			if err := l.Wait(ctx); err != nil {
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+			}
+			return next.HandleFinalize(ctx, in)
+		}), middleware.After)
+}
+
+// noRetryQuota lets every retryable error be retried. The SDK's default retry quota runs out after a burst of
+// throttling errors, which would abort an upgrade that only needs to slow down.
+type noRetryQuota struct{}
+
+func (noRetryQuota) GetToken(context.Context, uint) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+func (noRetryQuota) AddTokens(uint) error {
+	return nil
+}
+
+// configureAWS returns a copy of the AWS config that retries throttled calls with exponential backoff and
+// jitter, and that waits for the call limiter, if there is one, before each call
+func (u *Upgrader) configureAWS(awsCfg aws.Config) aws.Config {
+	cfg := awsCfg.Copy()
+	cfg.Retryer = func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = u.maxRetryAttempts
+			o.MaxBackoff = u.maxRetryBackoff
+			o.RateLimiter = noRetryQuota{}
+		})
+	}
+	if u.callLimiter != nil {
+		cfg.APIOptions = append(cfg.APIOptions, u.callLimiter.addMiddleware)
+	}
+	return cfg
+}
@@ -0,0 +1,31 @@
+package ead
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallLimiterReserve(t *testing.T) {
+	l := NewCallLimiter(4)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, want := range []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond} {
+		if got := l.reserve(start); got != want {
+			t.Errorf("call %d waits %s, want %s", i, got, want)
+		}
+	}
+
+	// slots that were reserved are still taken a little later
+	if got := l.reserve(start.Add(100 * time.Millisecond)); got != 650*time.Millisecond {
+		t.Errorf("got wait %s, want 650ms", got)
+	}
+
+	// after an idle period, a call doesn't wait and unused slots don't build up
+	later := start.Add(10 * time.Second)
+	if got := l.reserve(later); got != 0 {
+		t.Errorf("got wait %s after idle period, want 0", got)
+	}
+	if got := l.reserve(later); got != 250*time.Millisecond {
+		t.Errorf("got wait %s, want 250ms", got)
+	}
+}
@@ -0,0 +1,310 @@
+package ead
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+)
+
+// upgradeRun records the changes made by an upgrade, so that they can be undone if replacing instances
+// fails before any old instance is deregistered
+type upgradeRun struct {
+	asgName              string
+	launchTemplate       *asgTypes.LaunchTemplateSpecification
+	mixedInstancesPolicy *asgTypes.MixedInstancesPolicy
+	maxSize              int32
+
+	// defaultVersions and latestVersions hold the default and latest version of each launch template before
+	// the upgrade, by launch template ID
+	defaultVersions map[string]int64
+	latestVersions  map[string]int64
+	newVersions     []*ec2types.LaunchTemplateVersion
+
+	warmPoolRefreshed bool
+	asgInstanceIDs    []string
+	// replacedInstanceIDs are the old instances that were tagged for termination, and detached unless instances
+	// are kept in the ASG
+	replacedInstanceIDs []string
+	// retiredInstanceIDs are the replaced instances that have been deregistered from the cluster. Instances
+	// skipped for their standalone tasks are not retired.
+	retiredInstanceIDs []string
+	deregistered       bool
+}
+
+// newUpgradeRun records the state of the ASG and its launch templates before an upgrade changes them
+func newUpgradeRun(asg *asgTypes.AutoScalingGroup, templates ...*ec2types.LaunchTemplate) *upgradeRun {
+	run := &upgradeRun{
+		asgName:              *asg.AutoScalingGroupName,
+		launchTemplate:       asg.LaunchTemplate,
+		mixedInstancesPolicy: asg.MixedInstancesPolicy,
+		maxSize:              aws.ToInt32(asg.MaxSize),
+		defaultVersions:      map[string]int64{},
+		latestVersions:       map[string]int64{},
+	}
+	for _, lt := range templates {
+		run.defaultVersions[*lt.LaunchTemplateId] = aws.ToInt64(lt.DefaultVersionNumber)
+		run.latestVersions[*lt.LaunchTemplateId] = aws.ToInt64(lt.LatestVersionNumber)
+	}
+	for _, i := range asg.Instances {
+		run.asgInstanceIDs = append(run.asgInstanceIDs, *i.InstanceId)
+	}
+	return run
+}
+
+// abortUpgrade restores the ASG to its state before the upgrade if the failure policy allows it and no old
+// instance has been deregistered yet. Once old instances have been deregistered, failed tasks on the new
+// instances roll the ASG back to its previous launch template versions if the task failure policy allows it.
+// Nothing is undone if the upgrade lock was lost. The original error is returned, along with any error from
+// restoring.
+func (u *Upgrader) abortUpgrade(run *upgradeRun, err error) error {
+	// another run may be changing the ASG now
+	if u.lockErr() != nil {
+		return err
+	}
+
+	var taskFailures *taskFailureError
+	if run.deregistered && u.taskFailurePolicy == TaskFailurePolicyRollback && errors.As(err, &taskFailures) {
+		u.logger.Printf("Rolling ASG %s back to its previous launch template versions: %s", run.asgName, err)
+		if rollbackErr := u.rollBackLaunchTemplates(run); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back ASG %s: %w", run.asgName, rollbackErr))
+		}
+		u.logger.Printf("ASG %s rolled back, the new instances still run the new AMI and are not replaced", run.asgName)
+		return err
+	}
+
+	if u.failurePolicy != FailurePolicyRestore || run.deregistered {
+		return err
+	}
+
+	u.logger.Printf("Upgrade failed before any old instance was deregistered, restoring ASG %s: %s", run.asgName, err)
+	if restoreErr := u.restoreUpgradeRun(run); restoreErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to restore ASG %s, the next run will continue the upgrade: %w",
+			run.asgName, restoreErr))
+	}
+	u.logger.Printf("ASG %s restored to its previous launch template and instances", run.asgName)
+	return err
+}
+
+// restoreUpgradeRun points the ASG back at its previous launch template versions, reattaches the detached
+// instances, terminates the instances launched since and deletes the new launch template versions
+func (u *Upgrader) restoreUpgradeRun(run *upgradeRun) error {
+	if err := u.restoreLaunchTemplates(run, previousLaunchTemplateInput(run)); err != nil {
+		return err
+	}
+
+	asg, err := u.getAsgByName(run.asgName)
+	if err != nil {
+		return err
+	}
+	var current []string
+	for _, i := range asg.Instances {
+		current = append(current, *i.InstanceId)
+	}
+
+	if err := u.reattachInstances(asg, run, run.replacedInstanceIDs, true); err != nil {
+		return err
+	}
+
+	launched := launchedInstances(current, run.asgInstanceIDs)
+	for _, id := range launched {
+		u.logger.Printf("Terminating new instance %s", id)
+		_, err := u.asgClient.TerminateInstanceInAutoScalingGroup(context.Background(),
+			&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+				InstanceId:                     aws.String(id),
+				ShouldDecrementDesiredCapacity: aws.Bool(true),
+			})
+		if err != nil {
+			return fmt.Errorf("failed to terminate new instance %s: %w", id, err)
+		}
+	}
+
+	if aws.ToInt32(asg.MaxSize) != run.maxSize {
+		_, err := u.asgClient.UpdateAutoScalingGroup(context.Background(), &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(run.asgName),
+			MaxSize:              aws.Int32(run.maxSize),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore max size of ASG %s: %w", run.asgName, err)
+		}
+	}
+
+	// the warm pool may have been refilled from the new launch template version
+	if run.warmPoolRefreshed {
+		if err := u.refreshWarmPool(run.asgName); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range run.newVersions {
+		versionString := fmt.Sprintf("%d", *v.VersionNumber)
+		if err := u.deleteLaunchTemplateVersion(*v.LaunchTemplateName, versionString); err != nil {
+			return fmt.Errorf("error deleting launch template %s version %d: %w",
+				*v.LaunchTemplateName, *v.VersionNumber, err)
+		}
+	}
+	return nil
+}
+
+// restoreLaunchTemplates updates the ASG with input, which points it back at its previous launch template
+// versions, and restores the templates' previous default versions
+func (u *Upgrader) restoreLaunchTemplates(run *upgradeRun, input *autoscaling.UpdateAutoScalingGroupInput) error {
+	if _, err := u.asgClient.UpdateAutoScalingGroup(context.Background(), input); err != nil {
+		return fmt.Errorf("unable to restore the previous launch template: %w", err)
+	}
+
+	for id, version := range run.defaultVersions {
+		_, err := u.ec2Client.ModifyLaunchTemplate(context.Background(), &ec2.ModifyLaunchTemplateInput{
+			DefaultVersion:   aws.String(fmt.Sprintf("%d", version)),
+			LaunchTemplateId: aws.String(id),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore default version of launch template %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// reattachInstances attaches the given replaced instances that are still running back to the ASG and removes
+// their termination tags. If raiseMaxSize is true, the ASG's max size is raised if needed, and restoring the whole
+// run sets it back after the new instances are terminated. Otherwise instances that don't fit under the max size
+// are left detached and tagged, and an error is returned.
+func (u *Upgrader) reattachInstances(asg *asgTypes.AutoScalingGroup, run *upgradeRun, instanceIDs []string,
+	raiseMaxSize bool,
+) error {
+	tagged, err := u.findDetachedButRunningInstances(run.asgName)
+	if err != nil {
+		return err
+	}
+	var current []string
+	for _, i := range asg.Instances {
+		current = append(current, *i.InstanceId)
+	}
+	var attach []string
+	for _, id := range instanceIDs {
+		if internal.IsStringInSlice(id, tagged) && !internal.IsStringInSlice(id, current) {
+			attach = append(attach, id)
+		}
+	}
+
+	if len(attach) > 0 {
+		if size := aws.ToInt32(asg.DesiredCapacity) + int32(len(attach)); size > aws.ToInt32(asg.MaxSize) {
+			if !raiseMaxSize {
+				return fmt.Errorf("instances %s can't be reattached without raising the max size of ASG %s above %d, "+
+					"they are left detached and tagged for termination", strings.Join(attach, ", "), run.asgName,
+					aws.ToInt32(asg.MaxSize))
+			}
+			_, err := u.asgClient.UpdateAutoScalingGroup(context.Background(), &autoscaling.UpdateAutoScalingGroupInput{
+				AutoScalingGroupName: aws.String(run.asgName),
+				MaxSize:              aws.Int32(size),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to raise max size of ASG %s: %w", run.asgName, err)
+			}
+			asg.MaxSize = aws.Int32(size)
+		}
+
+		u.logger.Printf("Reattaching instances: %s", strings.Join(attach, ", "))
+		// AttachInstances accepts up to 20 instances per call
+		for i := 0; i < len(attach); i += 20 {
+			_, err := u.asgClient.AttachInstances(context.Background(), &autoscaling.AttachInstancesInput{
+				AutoScalingGroupName: aws.String(run.asgName),
+				InstanceIds:          attach[i:min(i+20, len(attach))],
+			})
+			if err != nil {
+				return fmt.Errorf("failed to reattach instances to ASG %s: %w", run.asgName, err)
+			}
+		}
+	}
+
+	// instances that failed to detach are tagged as well
+	var untag []string
+	for _, id := range instanceIDs {
+		if internal.IsStringInSlice(id, tagged) {
+			untag = append(untag, id)
+		}
+	}
+	if len(untag) == 0 {
+		return nil
+	}
+	_, err = u.ec2Client.DeleteTags(context.Background(), &ec2.DeleteTagsInput{
+		Resources: untag,
+		Tags: []ec2types.Tag{
+			{Key: aws.String(TagNameASG)},
+			{Key: aws.String(TagNameTerminate)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove termination tags: %w", err)
+	}
+	return nil
+}
+
+// previousLaunchTemplateInput returns the input to point the ASG back at the launch template versions it used
+// before the upgrade
+func previousLaunchTemplateInput(run *upgradeRun) *autoscaling.UpdateAutoScalingGroupInput {
+	input := &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(run.asgName),
+	}
+	if run.mixedInstancesPolicy != nil && run.mixedInstancesPolicy.LaunchTemplate != nil {
+		// without new versions, this only copies the policy in a form the update accepts
+		input.MixedInstancesPolicy = mixedInstancesPolicyWithVersions(run.mixedInstancesPolicy)
+	} else {
+		input.LaunchTemplate = launchTemplateSpecForUpdate(run.launchTemplate)
+	}
+	return input
+}
+
+// unretiredInstances returns the replaced instances that haven't been deregistered from the cluster
+func unretiredInstances(run *upgradeRun) []string {
+	var unretired []string
+	for _, id := range run.replacedInstanceIDs {
+		if !internal.IsStringInSlice(id, run.retiredInstanceIDs) {
+			unretired = append(unretired, id)
+		}
+	}
+	return unretired
+}
+
+// pinnedLaunchTemplateInput returns the input to point the ASG back at the launch template versions it used
+// before the upgrade, with "$Latest" references pinned to the version that was the latest before the upgrade.
+// A rollback keeps the new versions, so "$Latest" would still refer to the new AMI.
+func pinnedLaunchTemplateInput(run *upgradeRun) *autoscaling.UpdateAutoScalingGroupInput {
+	input := previousLaunchTemplateInput(run)
+	pin := func(spec *asgTypes.LaunchTemplateSpecification) {
+		if spec == nil || aws.ToString(spec.Version) != "$Latest" {
+			return
+		}
+		if version, ok := run.latestVersions[aws.ToString(spec.LaunchTemplateId)]; ok {
+			spec.Version = aws.String(fmt.Sprintf("%d", version))
+		}
+	}
+
+	pin(input.LaunchTemplate)
+	if input.MixedInstancesPolicy != nil {
+		pin(input.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification)
+		for _, o := range input.MixedInstancesPolicy.LaunchTemplate.Overrides {
+			pin(o.LaunchTemplateSpecification)
+		}
+	}
+	return input
+}
+
+// launchedInstances returns the instances in current that are not in original
+func launchedInstances(current, original []string) []string {
+	var launched []string
+	for _, id := range current {
+		if !internal.IsStringInSlice(id, original) {
+			launched = append(launched, id)
+		}
+	}
+	return launched
+}
@@ -0,0 +1,133 @@
+package ead
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+func TestPreviousLaunchTemplateInput(t *testing.T) {
+	run := &upgradeRun{
+		asgName: "ecs-prod",
+		launchTemplate: &asgTypes.LaunchTemplateSpecification{
+			LaunchTemplateId:   aws.String("lt-main"),
+			LaunchTemplateName: aws.String("ecs-prod"),
+			Version:            aws.String("7"),
+		},
+	}
+	input := previousLaunchTemplateInput(run)
+	if input.MixedInstancesPolicy != nil {
+		t.Error("ASG without a mixed instances policy should not get one")
+	}
+	want := &asgTypes.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-main"), Version: aws.String("7")}
+	if !reflect.DeepEqual(input.LaunchTemplate, want) {
+		t.Errorf("got launch template %+v, want %+v", input.LaunchTemplate, want)
+	}
+
+	run.launchTemplate = nil
+	run.mixedInstancesPolicy = &asgTypes.MixedInstancesPolicy{
+		LaunchTemplate: &asgTypes.LaunchTemplate{
+			LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+				LaunchTemplateId:   aws.String("lt-main"),
+				LaunchTemplateName: aws.String("ecs-prod"),
+				Version:            aws.String("$Latest"),
+			},
+			Overrides: []asgTypes.LaunchTemplateOverrides{
+				{InstanceType: aws.String("m5.large")},
+				{InstanceType: aws.String("m5.xlarge")},
+			},
+		},
+	}
+	input = previousLaunchTemplateInput(run)
+	if input.LaunchTemplate != nil {
+		t.Error("ASG with a mixed instances policy should not get a launch template")
+	}
+	spec := input.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	if aws.ToString(spec.LaunchTemplateId) != "lt-main" || spec.LaunchTemplateName != nil ||
+		aws.ToString(spec.Version) != "$Latest" {
+		t.Errorf("got policy launch template %+v, want lt-main version $Latest", spec)
+	}
+	if got := overrideInstanceTypes(input.MixedInstancesPolicy); !reflect.DeepEqual(got, []string{"m5.large", "m5.xlarge"}) {
+		t.Errorf("got override instance types %v, want the previous ones", got)
+	}
+}
+
+func TestPinnedLaunchTemplateInput(t *testing.T) {
+	run := &upgradeRun{
+		asgName: "ecs-prod",
+		launchTemplate: &asgTypes.LaunchTemplateSpecification{
+			LaunchTemplateId:   aws.String("lt-main"),
+			LaunchTemplateName: aws.String("ecs-prod"),
+			Version:            aws.String("$Latest"),
+		},
+		latestVersions: map[string]int64{"lt-main": 7, "lt-arm": 4},
+	}
+	input := pinnedLaunchTemplateInput(run)
+	want := &asgTypes.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-main"), Version: aws.String("7")}
+	if !reflect.DeepEqual(input.LaunchTemplate, want) {
+		t.Errorf("got launch template %+v, want %+v", input.LaunchTemplate, want)
+	}
+	if aws.ToString(run.launchTemplate.Version) != "$Latest" {
+		t.Error("the recorded launch template should not change")
+	}
+
+	run.launchTemplate = nil
+	run.mixedInstancesPolicy = &asgTypes.MixedInstancesPolicy{
+		LaunchTemplate: &asgTypes.LaunchTemplate{
+			LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-main"),
+				Version:          aws.String("$Latest"),
+			},
+			Overrides: []asgTypes.LaunchTemplateOverrides{
+				{InstanceType: aws.String("m5.large")},
+				{InstanceType: aws.String("m7g.large"), LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+					LaunchTemplateId: aws.String("lt-arm"),
+					Version:          aws.String("$Latest"),
+				}},
+				{InstanceType: aws.String("c5.large"), LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+					LaunchTemplateId: aws.String("lt-compute"),
+					Version:          aws.String("$Default"),
+				}},
+			},
+		},
+	}
+	policy := pinnedLaunchTemplateInput(run).MixedInstancesPolicy.LaunchTemplate
+	if v := aws.ToString(policy.LaunchTemplateSpecification.Version); v != "7" {
+		t.Errorf("policy launch template version is %s, want 7", v)
+	}
+	if v := aws.ToString(policy.Overrides[1].LaunchTemplateSpecification.Version); v != "4" {
+		t.Errorf("override launch template version is %s, want 4", v)
+	}
+	if v := aws.ToString(policy.Overrides[2].LaunchTemplateSpecification.Version); v != "$Default" {
+		t.Errorf("override launch template version is %s, want $Default", v)
+	}
+	if policy.Overrides[0].LaunchTemplateSpecification != nil {
+		t.Error("override without a launch template should not get one")
+	}
+}
+
+func TestUnretiredInstances(t *testing.T) {
+	run := &upgradeRun{
+		replacedInstanceIDs: []string{"i-1", "i-2", "i-3", "i-4"},
+		retiredInstanceIDs:  []string{"i-1", "i-2", "i-3"},
+	}
+	if got, want := unretiredInstances(run), []string{"i-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run.retiredInstanceIDs = append(run.retiredInstanceIDs, "i-4")
+	if got := unretiredInstances(run); got != nil {
+		t.Errorf("got %v, want none", got)
+	}
+}
+
+func TestLaunchedInstances(t *testing.T) {
+	got := launchedInstances([]string{"i-1", "i-4", "i-2", "i-5"}, []string{"i-1", "i-2", "i-3"})
+	if want := []string{"i-4", "i-5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := launchedInstances([]string{"i-1"}, []string{"i-1", "i-2"}); got != nil {
+		t.Errorf("got %v, want none", got)
+	}
+}
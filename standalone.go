@@ -0,0 +1,176 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// StandaloneTask is a task that doesn't belong to a service, such as a batch job or a scheduled task,
+// running on a container instance that is to be replaced
+type StandaloneTask struct {
+	Instance          string
+	ContainerInstance string
+	TaskArn           string
+	TaskDefinition    string
+	Group             string
+	StartedAt         *time.Time
+}
+
+func (t StandaloneTask) String() string {
+	s := fmt.Sprintf("%s (%s, group %s)", t.TaskArn, t.TaskDefinition, t.Group)
+	if t.StartedAt != nil {
+		s += ", started " + t.StartedAt.Format(time.RFC3339)
+	}
+	return s
+}
+
+// listStandaloneTasks returns the standalone tasks on each of the cluster's container instances, by EC2
+// instance ID
+func (u *Upgrader) listStandaloneTasks() (map[string][]StandaloneTask, error) {
+	instances, err := u.getInstanceListForCluster(u.cluster)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := u.listClusterTasks("")
+	if err != nil {
+		return nil, err
+	}
+	return standaloneTasksByInstance(tasks, instances), nil
+}
+
+// standaloneTasksByInstance returns the standalone tasks that haven't stopped, grouped by the EC2 instance ID of
+// their container instance
+func standaloneTasksByInstance(tasks []ecsTypes.Task, instances []ecsTypes.ContainerInstance) map[string][]StandaloneTask {
+	instanceIDs := map[string]string{}
+	for _, i := range instances {
+		instanceIDs[aws.ToString(i.ContainerInstanceArn)] = aws.ToString(i.Ec2InstanceId)
+	}
+
+	byInstance := map[string][]StandaloneTask{}
+	for _, t := range tasks {
+		if !isStandaloneTask(t) || aws.ToString(t.LastStatus) == "STOPPED" {
+			continue
+		}
+		id, ok := instanceIDs[aws.ToString(t.ContainerInstanceArn)]
+		if !ok {
+			continue
+		}
+		byInstance[id] = append(byInstance[id], StandaloneTask{
+			Instance:          id,
+			ContainerInstance: aws.ToString(t.ContainerInstanceArn),
+			TaskArn:           aws.ToString(t.TaskArn),
+			TaskDefinition:    aws.ToString(t.TaskDefinitionArn),
+			Group:             aws.ToString(t.Group),
+			StartedAt:         t.StartedAt,
+		})
+	}
+	return byInstance
+}
+
+// containerInstanceArnForInstance returns the ARN of the container instance for an EC2 instance, or an empty
+// string if it isn't registered with the cluster
+func containerInstanceArnForInstance(instances []ecsTypes.ContainerInstance, instanceID string) string {
+	for _, i := range instances {
+		if aws.ToString(i.Ec2InstanceId) == instanceID {
+			return aws.ToString(i.ContainerInstanceArn)
+		}
+	}
+	return ""
+}
+
+// clearStandaloneTasks applies the standalone task policy to an instance that is about to be removed. It returns
+// false if the instance should be left for a later run. With the wait and skip policies, an instance with
+// standalone tasks is drained first, so that no new tasks are placed on it.
+func (u *Upgrader) clearStandaloneTasks(instanceID, containerInstanceArn string) (bool, error) {
+	tasks, err := u.listInstanceStandaloneTasks(instanceID, containerInstanceArn)
+	if err != nil {
+		return false, err
+	}
+	if len(tasks) == 0 {
+		return true, nil
+	}
+	for _, t := range tasks {
+		u.logger.Printf("Standalone task on instance %s: %s", instanceID, t)
+	}
+
+	if u.standaloneTaskPolicy == StandaloneTaskPolicyProceed {
+		u.logger.Printf("Removing instance %s will stop %d standalone tasks", instanceID, len(tasks))
+		return true, nil
+	}
+
+	if err := u.drainContainerInstance(containerInstanceArn); err != nil {
+		return false, err
+	}
+
+	if u.standaloneTaskPolicy == StandaloneTaskPolicySkip {
+		u.logger.Printf("Skipping instance %s until its standalone tasks finish, it will be replaced by a later run",
+			instanceID)
+		return false, nil
+	}
+
+	u.logger.Printf("Waiting up to %s for standalone tasks on instance %s to finish", u.standaloneTaskTimeout, instanceID)
+	startTime := time.Now()
+	for {
+		if time.Since(startTime) >= u.standaloneTaskTimeout {
+			return false, fmt.Errorf("timeout while waiting for %d standalone tasks on instance %s to finish",
+				len(tasks), instanceID)
+		}
+		if err := u.pause(u.pollingInterval); err != nil {
+			return false, err
+		}
+
+		tasks, err = u.listInstanceStandaloneTasks(instanceID, containerInstanceArn)
+		if err != nil {
+			return false, err
+		}
+		if len(tasks) == 0 {
+			u.logger.Printf("Standalone tasks on instance %s have finished", instanceID)
+			return true, nil
+		}
+		u.logger.Printf("Still waiting for %d standalone tasks on instance %s", len(tasks), instanceID)
+	}
+}
+
+// listInstanceStandaloneTasks returns the standalone tasks on one container instance
+func (u *Upgrader) listInstanceStandaloneTasks(instanceID, containerInstanceArn string) ([]StandaloneTask, error) {
+	tasks, err := u.listClusterTasks(containerInstanceArn)
+	if err != nil {
+		return nil, err
+	}
+	instance := ecsTypes.ContainerInstance{
+		ContainerInstanceArn: aws.String(containerInstanceArn),
+		Ec2InstanceId:        aws.String(instanceID),
+	}
+	return standaloneTasksByInstance(tasks, []ecsTypes.ContainerInstance{instance})[instanceID], nil
+}
+
+// drainContainerInstance sets the container instance to DRAINING, so that service tasks move elsewhere and no new
+// tasks are placed on it
+func (u *Upgrader) drainContainerInstance(containerInstanceArn string) error {
+	u.logger.Printf("Draining container instance %s", containerInstanceArn)
+	_, err := u.ecsClient.UpdateContainerInstancesState(context.Background(), &ecs.UpdateContainerInstancesStateInput{
+		Cluster:            aws.String(u.cluster),
+		ContainerInstances: []string{containerInstanceArn},
+		Status:             ecsTypes.ContainerInstanceStatusDraining,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to drain container instance %s: %w", containerInstanceArn, err)
+	}
+	return nil
+}
+
+// isTaggedForTermination returns true if the instance was tagged to be terminated by a previous or current run
+func isTaggedForTermination(instance ec2types.Instance) bool {
+	for _, t := range instance.Tags {
+		if aws.ToString(t.Key) == TagNameTerminate && aws.ToString(t.Value) == "true" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package ead
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestStandaloneTasksByInstance(t *testing.T) {
+	instances := []ecsTypes.ContainerInstance{
+		{ContainerInstanceArn: aws.String("ci-1"), Ec2InstanceId: aws.String("i-1")},
+		{ContainerInstanceArn: aws.String("ci-2"), Ec2InstanceId: aws.String("i-2")},
+	}
+	tasks := []ecsTypes.Task{
+		{TaskArn: aws.String("service-task"), ContainerInstanceArn: aws.String("ci-1"), Group: aws.String("service:web"),
+			LastStatus: aws.String("RUNNING")},
+		{TaskArn: aws.String("batch-job"), ContainerInstanceArn: aws.String("ci-1"), Group: aws.String("family:batch"),
+			LastStatus: aws.String("RUNNING"), TaskDefinitionArn: aws.String("batch:3")},
+		{TaskArn: aws.String("stopped-job"), ContainerInstanceArn: aws.String("ci-1"), Group: aws.String("family:batch"),
+			LastStatus: aws.String("STOPPED")},
+		{TaskArn: aws.String("scheduled"), ContainerInstanceArn: aws.String("ci-2"), Group: aws.String("scheduled"),
+			LastStatus: aws.String("PENDING")},
+		{TaskArn: aws.String("elsewhere"), ContainerInstanceArn: aws.String("ci-3"), Group: aws.String("family:batch"),
+			LastStatus: aws.String("RUNNING")},
+	}
+
+	got := standaloneTasksByInstance(tasks, instances)
+	if len(got) != 2 {
+		t.Fatalf("got tasks on %d instances, want 2", len(got))
+	}
+	if len(got["i-1"]) != 1 || got["i-1"][0].TaskArn != "batch-job" {
+		t.Errorf("got %v on i-1, want only batch-job", got["i-1"])
+	}
+	if task := got["i-1"][0]; task.Instance != "i-1" || task.ContainerInstance != "ci-1" || task.TaskDefinition != "batch:3" {
+		t.Errorf("got task %+v, want instance i-1, container instance ci-1 and task definition batch:3", task)
+	}
+	if len(got["i-2"]) != 1 || got["i-2"][0].TaskArn != "scheduled" {
+		t.Errorf("got %v on i-2, want only scheduled", got["i-2"])
+	}
+}
+
+func TestContainerInstanceArnForInstance(t *testing.T) {
+	instances := []ecsTypes.ContainerInstance{
+		{ContainerInstanceArn: aws.String("ci-1"), Ec2InstanceId: aws.String("i-1")},
+		{ContainerInstanceArn: aws.String("ci-2"), Ec2InstanceId: aws.String("i-2")},
+	}
+	if got := containerInstanceArnForInstance(instances, "i-2"); got != "ci-2" {
+		t.Errorf("got %q, want ci-2", got)
+	}
+	if got := containerInstanceArnForInstance(instances, "i-3"); got != "" {
+		t.Errorf("got %q for an unregistered instance, want none", got)
+	}
+}
+
+func TestIsTaggedForTermination(t *testing.T) {
+	tagged := ec2types.Instance{Tags: []ec2types.Tag{
+		{Key: aws.String(TagNameASG), Value: aws.String("ecs-prod")},
+		{Key: aws.String(TagNameTerminate), Value: aws.String("true")},
+	}}
+	if !isTaggedForTermination(tagged) {
+		t.Error("instance with the terminate tag should be tagged for termination")
+	}
+	untagged := ec2types.Instance{Tags: []ec2types.Tag{{Key: aws.String("Name"), Value: aws.String("ecs-prod")}}}
+	if isTaggedForTermination(untagged) {
+		t.Error("instance without the terminate tag should not be tagged for termination")
+	}
+}
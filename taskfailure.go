@@ -0,0 +1,156 @@
+package ead
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+)
+
+// taskFailureWatch tracks the tasks that stopped because they failed on container instances added by an
+// upgrade, which points to a problem with the new AMI
+type taskFailureWatch struct {
+	// originalContainerInstances are the ARNs of the container instances from before the upgrade
+	originalContainerInstances []string
+	since                      time.Time
+	seen                       map[string]bool
+	failures                   []string
+}
+
+func newTaskFailureWatch(originalContainerInstances []string) *taskFailureWatch {
+	return &taskFailureWatch{
+		originalContainerInstances: originalContainerInstances,
+		since:                      time.Now(),
+		seen:                       map[string]bool{},
+	}
+}
+
+// taskFailureError is returned when the number of failed tasks reaches the task failure threshold
+type taskFailureError struct {
+	failures []string
+}
+
+func (e *taskFailureError) Error() string {
+	return fmt.Sprintf("%d tasks failed on the new container instances, the new AMI may not work for this "+
+		"cluster: %s", len(e.failures), strings.Join(e.failures, "; "))
+}
+
+// checkTaskFailures looks for newly stopped tasks on the new container instances and returns a taskFailureError
+// once the number of failed tasks reaches the threshold. It does nothing unless an upgrade is watching for
+// failed tasks.
+func (u *Upgrader) checkTaskFailures() error {
+	w := u.taskFailures
+	if w == nil || u.taskFailurePolicy == TaskFailurePolicyIgnore {
+		return nil
+	}
+
+	input := &ecs.ListTasksInput{
+		Cluster:       aws.String(u.cluster),
+		DesiredStatus: ecsTypes.DesiredStatusStopped,
+		MaxResults:    aws.Int32(100),
+	}
+	var taskArns []string
+	paginator := ecs.NewListTasksPaginator(u.ecsClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return fmt.Errorf("error listing stopped tasks: %s", err)
+		}
+		for _, arn := range page.TaskArns {
+			if !w.seen[arn] {
+				taskArns = append(taskArns, arn)
+			}
+		}
+	}
+
+	tasks, err := u.describeTasks(taskArns)
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		// tasks that are still stopping are checked again once they have stopped
+		if aws.ToString(t.LastStatus) != "STOPPED" {
+			continue
+		}
+		w.seen[aws.ToString(t.TaskArn)] = true
+
+		arn := aws.ToString(t.ContainerInstanceArn)
+		if arn == "" || internal.IsStringInSlice(arn, w.originalContainerInstances) ||
+			(t.StoppedAt != nil && t.StoppedAt.Before(w.since)) {
+			continue
+		}
+		if reason := taskFailureReason(t); reason != "" {
+			failure := fmt.Sprintf("task %s (%s): %s", aws.ToString(t.TaskArn), aws.ToString(t.Group), reason)
+			u.logger.Printf("Task failed on new container instance %s: %s", arn, failure)
+			w.failures = append(w.failures, failure)
+		}
+	}
+
+	if len(w.failures) >= u.taskFailureThreshold {
+		return &taskFailureError{failures: w.failures}
+	}
+	return nil
+}
+
+// taskFailureReason explains why a stopped task failed, or returns an empty string if it stopped for a reason
+// that has nothing to do with its container instance, such as being stopped by a user, scaled in, or finishing
+// successfully
+func taskFailureReason(t ecsTypes.Task) string {
+	switch t.StopCode {
+	case ecsTypes.TaskStopCodeTaskFailedToStart:
+		return "failed to start: " + aws.ToString(t.StoppedReason)
+	case ecsTypes.TaskStopCodeEssentialContainerExited:
+		var reasons []string
+		for _, c := range t.Containers {
+			switch {
+			case c.ExitCode != nil && *c.ExitCode != 0:
+				reason := fmt.Sprintf("container %s exited with code %d", aws.ToString(c.Name), *c.ExitCode)
+				if c.Reason != nil {
+					reason += ": " + aws.ToString(c.Reason)
+				}
+				reasons = append(reasons, reason)
+			case c.ExitCode == nil && aws.ToString(c.Reason) != "":
+				reasons = append(reasons, fmt.Sprintf("container %s: %s", aws.ToString(c.Name), aws.ToString(c.Reason)))
+			}
+		}
+		return strings.Join(reasons, ", ")
+	case ecsTypes.TaskStopCodeServiceSchedulerInitiated:
+		if strings.Contains(strings.ToLower(aws.ToString(t.StoppedReason)), "health check") {
+			return aws.ToString(t.StoppedReason)
+		}
+	}
+	return ""
+}
+
+// rollBackLaunchTemplates points the ASG back at its previous launch template versions, pinning "$Latest"
+// references to the versions that were the latest before the upgrade, and restores their default versions, so
+// that instances launched from now on use the previous AMI. The old instances that were replaced but not yet
+// retired are reattached and their termination tags removed, so that they keep serving. The ASG's max size is
+// not raised for them, so if they don't fit under it they are left detached and an error says so. The new
+// instances are not replaced, so those that are already running keep the new AMI.
+func (u *Upgrader) rollBackLaunchTemplates(run *upgradeRun) error {
+	if err := u.restoreLaunchTemplates(run, pinnedLaunchTemplateInput(run)); err != nil {
+		return err
+	}
+
+	asg, err := u.getAsgByName(run.asgName)
+	if err != nil {
+		return err
+	}
+	reattachErr := u.reattachInstances(asg, run, unretiredInstances(run), false)
+
+	// the warm pool was refilled from the new launch template version
+	if run.warmPoolRefreshed {
+		if err := u.refreshWarmPool(run.asgName); err != nil {
+			return errors.Join(reattachErr, err)
+		}
+	}
+	return reattachErr
+}
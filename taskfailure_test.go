@@ -0,0 +1,88 @@
+package ead
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestTaskFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		task ecsTypes.Task
+		want string
+	}{
+		{
+			name: "failed to start",
+			task: ecsTypes.Task{
+				StopCode:      ecsTypes.TaskStopCodeTaskFailedToStart,
+				StoppedReason: aws.String("CannotPullContainerError"),
+			},
+			want: "failed to start: CannotPullContainerError",
+		},
+		{
+			name: "container crashed",
+			task: ecsTypes.Task{
+				StopCode: ecsTypes.TaskStopCodeEssentialContainerExited,
+				Containers: []ecsTypes.Container{
+					{Name: aws.String("sidecar"), ExitCode: aws.Int32(0)},
+					{Name: aws.String("app"), ExitCode: aws.Int32(139)},
+				},
+			},
+			want: "container app exited with code 139",
+		},
+		{
+			name: "out of memory",
+			task: ecsTypes.Task{
+				StopCode:   ecsTypes.TaskStopCodeEssentialContainerExited,
+				Containers: []ecsTypes.Container{{Name: aws.String("app"), Reason: aws.String("OutOfMemoryError")}},
+			},
+			want: "container app: OutOfMemoryError",
+		},
+		{
+			name: "finished",
+			task: ecsTypes.Task{
+				StopCode:   ecsTypes.TaskStopCodeEssentialContainerExited,
+				Containers: []ecsTypes.Container{{Name: aws.String("job"), ExitCode: aws.Int32(0)}},
+			},
+			want: "",
+		},
+		{
+			name: "failed health checks",
+			task: ecsTypes.Task{
+				StopCode:      ecsTypes.TaskStopCodeServiceSchedulerInitiated,
+				StoppedReason: aws.String("Task failed ELB health checks in (target-group arn:aws:...)"),
+			},
+			want: "Task failed ELB health checks in (target-group arn:aws:...)",
+		},
+		{
+			name: "scaled in",
+			task: ecsTypes.Task{
+				StopCode:      ecsTypes.TaskStopCodeServiceSchedulerInitiated,
+				StoppedReason: aws.String("Scaling activity initiated by deployment ecs-svc/123"),
+			},
+			want: "",
+		},
+		{
+			name: "stopped by user",
+			task: ecsTypes.Task{StopCode: ecsTypes.TaskStopCodeUserInitiated, StoppedReason: aws.String("stopped")},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := taskFailureReason(tt.task); got != tt.want {
+				t.Errorf("taskFailureReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskFailureError(t *testing.T) {
+	err := &taskFailureError{failures: []string{"task a: failed to start", "task b: container app exited with code 1"}}
+	if !strings.HasPrefix(err.Error(), "2 tasks failed on the new container instances") {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
@@ -22,20 +22,66 @@ import (
 )
 
 type Upgrader struct {
-	amiFilter                string
-	cluster                  string
-	forceReplacement         bool
-	launchTemplateLimit      int
-	launchTemplateNamePrefix string
-	logger                   *log.Logger
-	pollingInterval          time.Duration
-	pollingTimeout           time.Duration
-	timestampLayout          string
+	abortOnHighRisk            bool
+	amiFilter                  string
+	amiOwners                  []string
+	batchSize                  int
+	callLimiter                *CallLimiter
+	capacityPolicy             CapacityPolicy
+	cluster                    string
+	defaultVersionPolicy       DefaultVersionPolicy
+	deploymentInterval         time.Duration
+	deploymentTimeout          time.Duration
+	failurePolicy              FailurePolicy
+	forceReplacement           bool
+	inServiceInterval          time.Duration
+	inServiceTimeout           time.Duration
+	instanceType               string
+	instanceTypeOverrides      []string
+	keepInstancesInASG         bool
+	launchTemplateLimit        int
+	launchTemplateMutators     []LaunchTemplateMutator
+	launchTemplateNamePrefix   string
+	launchTemplateRetentionAge time.Duration
+	lifecycleHookTimeout       time.Duration
+	lockOwner                  string
+	lockTTL                    time.Duration
+	logger                     *log.Logger
+	maxRetryAttempts           int
+	maxRetryBackoff            time.Duration
+	minimumAgentVersion        string
+	minimumIntervalsForStable  int
+	minimumRegisteredCPU       int32
+	minimumRegisteredMemory    int32
+	pollingInterval            time.Duration
+	pollingTimeout             time.Duration
+	registrationInterval       time.Duration
+	registrationTimeout        time.Duration
+	requiredAttributes         []string
+	resourceFitTimeout         time.Duration
+	runID                      string
+	skipAMIUpgrade             bool
+	stabilityInterval          time.Duration
+	stabilityTimeout           time.Duration
+	standaloneTaskPolicy       StandaloneTaskPolicy
+	standaloneTaskTimeout      time.Duration
+	suspendReplaceUnhealthy    bool
+	taskFailurePolicy          TaskFailurePolicy
+	taskFailureThreshold       int
+	timestampLayout            string
+	toolVersion                string
+	warmPoolInterval           time.Duration
+	warmPoolTimeout            time.Duration
 
 	awsCfg    aws.Config
 	asgClient *autoscaling.Client
 	ec2Client *ec2.Client
 	ecsClient *ecs.Client
+
+	// taskFailures watches for failed tasks on the new container instances while an upgrade is running
+	taskFailures *taskFailureWatch
+	// lockCtx is canceled if the upgrade lock is lost while an upgrade is running
+	lockCtx context.Context
 }
 
 func NewUpgrader(awsCfg aws.Config, config *Config) (*Upgrader, error) {
@@ -55,9 +101,10 @@ func NewUpgrader(awsCfg aws.Config, config *Config) (*Upgrader, error) {
 		return nil, fmt.Errorf("error loading config: %s", err)
 	}
 
-	upgrader.asgClient = autoscaling.NewFromConfig(awsCfg)
-	upgrader.ec2Client = ec2.NewFromConfig(awsCfg)
-	upgrader.ecsClient = ecs.NewFromConfig(awsCfg)
+	upgrader.awsCfg = upgrader.configureAWS(awsCfg)
+	upgrader.asgClient = autoscaling.NewFromConfig(upgrader.awsCfg)
+	upgrader.ec2Client = ec2.NewFromConfig(upgrader.awsCfg)
+	upgrader.ecsClient = ecs.NewFromConfig(upgrader.awsCfg)
 
 	return upgrader, nil
 }
@@ -66,6 +113,25 @@ func (u *Upgrader) loadConfig(config *Config) error {
 	if config.AMIFilter == "" {
 		config.AMIFilter = DefaultConfig.AMIFilter
 	}
+	if len(config.AMIOwners) == 0 {
+		config.AMIOwners = DefaultConfig.AMIOwners
+	}
+	if config.CallLimiter == nil && config.CallsPerSecond > 0 {
+		config.CallLimiter = NewCallLimiter(config.CallsPerSecond)
+	}
+	switch config.CapacityPolicy {
+	case "":
+		config.CapacityPolicy = DefaultConfig.CapacityPolicy
+	case CapacityPolicyFail, CapacityPolicyAuto:
+	default:
+		return fmt.Errorf("invalid capacity policy %q", config.CapacityPolicy)
+	}
+	if config.LockOwner == "" {
+		config.LockOwner = defaultLockOwner()
+	}
+	if config.LockTTL == 0 {
+		config.LockTTL = DefaultConfig.LockTTL
+	}
 	if config.Logger == nil {
 		config.Logger = log.Default()
 		config.Logger.SetOutput(os.Stdout)
@@ -73,28 +139,162 @@ func (u *Upgrader) loadConfig(config *Config) error {
 	if config.LaunchTemplateNamePrefix == "" {
 		config.LaunchTemplateNamePrefix = "ecs-" + config.Cluster
 	}
+	switch config.DefaultVersionPolicy {
+	case "":
+		config.DefaultVersionPolicy = DefaultConfig.DefaultVersionPolicy
+	case DefaultVersionPolicyAlways, DefaultVersionPolicyAuto, DefaultVersionPolicyNever:
+	default:
+		return fmt.Errorf("invalid default version policy %q", config.DefaultVersionPolicy)
+	}
+	switch config.FailurePolicy {
+	case "":
+		config.FailurePolicy = DefaultConfig.FailurePolicy
+	case FailurePolicyRestore, FailurePolicyNone:
+	default:
+		return fmt.Errorf("invalid failure policy %q", config.FailurePolicy)
+	}
 	if config.LaunchTemplateLimit == 0 {
 		config.LaunchTemplateLimit = DefaultConfig.LaunchTemplateLimit
 	}
+	if config.MaxRetryAttempts == 0 {
+		config.MaxRetryAttempts = DefaultConfig.MaxRetryAttempts
+	}
+	if config.MaxRetryBackoff == 0 {
+		config.MaxRetryBackoff = DefaultConfig.MaxRetryBackoff
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"deployment interval", config.DeploymentInterval}, {"deployment timeout", config.DeploymentTimeout},
+		{"in-service interval", config.InServiceInterval}, {"in-service timeout", config.InServiceTimeout},
+		{"launch template retention age", config.LaunchTemplateRetentionAge},
+		{"lifecycle hook timeout", config.LifecycleHookTimeout}, {"lock TTL", config.LockTTL},
+		{"max retry backoff", config.MaxRetryBackoff},
+		{"polling interval", config.PollingInterval}, {"polling timeout", config.PollingTimeout},
+		{"registration interval", config.RegistrationInterval}, {"registration timeout", config.RegistrationTimeout},
+		{"resource fit timeout", config.ResourceFitTimeout},
+		{"stability interval", config.StabilityInterval}, {"stability timeout", config.StabilityTimeout},
+		{"standalone task timeout", config.StandaloneTaskTimeout},
+		{"warm pool interval", config.WarmPoolInterval}, {"warm pool timeout", config.WarmPoolTimeout},
+	} {
+		if d.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", d.name, d.value)
+		}
+	}
+	if config.LaunchTemplateLimit < 0 {
+		return fmt.Errorf("launch template limit must not be negative, got %d", config.LaunchTemplateLimit)
+	}
+	if config.MinimumIntervalsForStable < 0 {
+		return fmt.Errorf("minimum intervals for stable must not be negative, got %d", config.MinimumIntervalsForStable)
+	}
+	// zero is replaced by the default threshold below
+	if config.TaskFailureThreshold < 0 {
+		return fmt.Errorf("task failure threshold must be at least 1, got %d", config.TaskFailureThreshold)
+	}
 	if config.PollingInterval == 0 {
 		config.PollingInterval = DefaultConfig.PollingInterval
 	}
 	if config.PollingTimeout == 0 {
 		config.PollingTimeout = DefaultConfig.PollingTimeout
 	}
+	// the in-service waiter keeps its own backoff unless its interval is set
+	for _, d := range []*time.Duration{&config.DeploymentInterval, &config.RegistrationInterval,
+		&config.StabilityInterval, &config.WarmPoolInterval} {
+		if *d == 0 {
+			*d = config.PollingInterval
+		}
+	}
+	for _, d := range []*time.Duration{&config.DeploymentTimeout, &config.InServiceTimeout,
+		&config.LifecycleHookTimeout, &config.RegistrationTimeout, &config.ResourceFitTimeout, &config.StabilityTimeout,
+		&config.StandaloneTaskTimeout, &config.WarmPoolTimeout} {
+		if *d == 0 {
+			*d = config.PollingTimeout
+		}
+	}
+	if config.MinimumIntervalsForStable == 0 {
+		config.MinimumIntervalsForStable = DefaultConfig.MinimumIntervalsForStable
+	}
+	if config.RunID == "" {
+		config.RunID = newRunID()
+	}
+	if config.SkipAMIUpgrade && config.InstanceType == "" && len(config.InstanceTypeOverrides) == 0 {
+		return fmt.Errorf("skipping the AMI upgrade requires an instance type or instance type overrides")
+	}
+	switch config.StandaloneTaskPolicy {
+	case "":
+		config.StandaloneTaskPolicy = DefaultConfig.StandaloneTaskPolicy
+	case StandaloneTaskPolicyProceed, StandaloneTaskPolicyWait, StandaloneTaskPolicySkip:
+	default:
+		return fmt.Errorf("invalid standalone task policy %q", config.StandaloneTaskPolicy)
+	}
+	switch config.TaskFailurePolicy {
+	case "":
+		config.TaskFailurePolicy = DefaultConfig.TaskFailurePolicy
+	case TaskFailurePolicyAbort, TaskFailurePolicyRollback, TaskFailurePolicyIgnore:
+	default:
+		return fmt.Errorf("invalid task failure policy %q", config.TaskFailurePolicy)
+	}
+	if config.TaskFailureThreshold == 0 {
+		config.TaskFailureThreshold = DefaultConfig.TaskFailureThreshold
+	}
 	if config.TimestampLayout == "" {
 		config.TimestampLayout = DefaultConfig.TimestampLayout
 	}
+	if config.ToolVersion == "" {
+		config.ToolVersion = DefaultConfig.ToolVersion
+	}
 
+	u.abortOnHighRisk = config.AbortOnHighRisk
 	u.amiFilter = config.AMIFilter
+	u.amiOwners = config.AMIOwners
+	u.batchSize = config.BatchSize
+	u.callLimiter = config.CallLimiter
+	u.capacityPolicy = config.CapacityPolicy
 	u.cluster = config.Cluster
+	u.defaultVersionPolicy = config.DefaultVersionPolicy
+	u.deploymentInterval = config.DeploymentInterval
+	u.deploymentTimeout = config.DeploymentTimeout
+	u.failurePolicy = config.FailurePolicy
 	u.forceReplacement = config.ForceReplacement
+	u.inServiceInterval = config.InServiceInterval
+	u.inServiceTimeout = config.InServiceTimeout
+	u.instanceType = config.InstanceType
+	u.instanceTypeOverrides = config.InstanceTypeOverrides
+	u.keepInstancesInASG = config.KeepInstancesInASG
 	u.launchTemplateLimit = config.LaunchTemplateLimit
+	u.launchTemplateMutators = config.LaunchTemplateMutators
 	u.launchTemplateNamePrefix = config.LaunchTemplateNamePrefix
+	u.launchTemplateRetentionAge = config.LaunchTemplateRetentionAge
+	u.lifecycleHookTimeout = config.LifecycleHookTimeout
+	u.lockOwner = config.LockOwner
+	u.lockTTL = config.LockTTL
 	u.logger = config.Logger
+	u.maxRetryAttempts = config.MaxRetryAttempts
+	u.maxRetryBackoff = config.MaxRetryBackoff
+	u.minimumAgentVersion = config.MinimumAgentVersion
+	u.minimumIntervalsForStable = config.MinimumIntervalsForStable
+	u.minimumRegisteredCPU = config.MinimumRegisteredCPU
+	u.minimumRegisteredMemory = config.MinimumRegisteredMemory
 	u.pollingInterval = config.PollingInterval
 	u.pollingTimeout = config.PollingTimeout
+	u.registrationInterval = config.RegistrationInterval
+	u.registrationTimeout = config.RegistrationTimeout
+	u.requiredAttributes = config.RequiredAttributes
+	u.resourceFitTimeout = config.ResourceFitTimeout
+	u.runID = config.RunID
+	u.skipAMIUpgrade = config.SkipAMIUpgrade
+	u.stabilityInterval = config.StabilityInterval
+	u.stabilityTimeout = config.StabilityTimeout
+	u.standaloneTaskPolicy = config.StandaloneTaskPolicy
+	u.standaloneTaskTimeout = config.StandaloneTaskTimeout
+	u.suspendReplaceUnhealthy = config.SuspendReplaceUnhealthy
+	u.taskFailurePolicy = config.TaskFailurePolicy
+	u.taskFailureThreshold = config.TaskFailureThreshold
 	u.timestampLayout = config.TimestampLayout
+	u.toolVersion = config.ToolVersion
+	u.warmPoolInterval = config.WarmPoolInterval
+	u.warmPoolTimeout = config.WarmPoolTimeout
 
 	return nil
 }
@@ -102,6 +302,25 @@ func (u *Upgrader) loadConfig(config *Config) error {
 // LatestAMI finds the latest ECS optimized AMI for the given (or default) filter
 // and returns the ec2types.Image and/or an error
 func (u *Upgrader) LatestAMI() (ec2types.Image, error) {
+	images, err := u.describeImages(false)
+	if err != nil {
+		return ec2types.Image{}, err
+	}
+
+	if len(images) == 0 {
+		return ec2types.Image{}, nil
+	}
+
+	return images[0], nil
+}
+
+// ListAMIs returns every image, including deprecated images, that matches the configured filter and
+// owners, sorted newest first
+func (u *Upgrader) ListAMIs() ([]ec2types.Image, error) {
+	return u.describeImages(true)
+}
+
+func (u *Upgrader) describeImages(includeDeprecated bool) ([]ec2types.Image, error) {
 	descInput := &ec2.DescribeImagesInput{
 		Filters: []ec2types.Filter{
 			{
@@ -111,30 +330,26 @@ func (u *Upgrader) LatestAMI() (ec2types.Image, error) {
 				},
 			},
 		},
-		Owners: []string{"amazon"},
-	}
-
-	output, err := u.ec2Client.DescribeImages(context.Background(), descInput)
-	if err != nil {
-		return ec2types.Image{}, err
-	}
-
-	if len(output.Images) == 0 {
-		return ec2types.Image{}, nil
+		IncludeDeprecated: aws.Bool(includeDeprecated),
+		MaxResults:        aws.Int32(1000),
+		Owners:            u.amiOwners,
 	}
 
-	newest := output.Images[0]
-	for _, img := range output.Images {
-		isNewer, err := isNewerImage(newest, img)
+	var images []ec2types.Image
+	paginator := ec2.NewDescribeImagesPaginator(u.ec2Client, descInput)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
 		if err != nil {
-			return ec2types.Image{}, err
-		}
-		if isNewer {
-			newest = img
+			return nil, err
 		}
+		images = append(images, page.Images...)
 	}
 
-	return newest, nil
+	if err := reverseSortImages(images); err != nil {
+		return nil, err
+	}
+
+	return images, nil
 }
 
 func (u *Upgrader) ListClusters() ([]ClusterMeta, error) {
@@ -168,6 +383,7 @@ func (u *Upgrader) ListClusters() ([]ClusterMeta, error) {
 						ImageId:      aws.String("na"),
 						Name:         aws.String(fmt.Sprintf("%s", err.Error())),
 					},
+					Region: u.awsCfg.Region,
 				})
 				continue
 			}
@@ -185,6 +401,7 @@ func (u *Upgrader) ListClusters() ([]ClusterMeta, error) {
 			allClusters = append(allClusters, ClusterMeta{
 				Cluster: c,
 				Image:   img,
+				Region:  u.awsCfg.Region,
 			})
 		}
 	}
@@ -202,60 +419,82 @@ func (u *Upgrader) UpgradeCluster() error {
 	}
 
 	startTime := time.Now()
-	u.logger.Printf("Beginning upgrade for ECS cluster %s using AMI filter %s\n", u.cluster, u.amiFilter)
+	u.logger.Printf("Beginning upgrade for ECS cluster %s using AMI filter %s, run ID %s\n", u.cluster, u.amiFilter, u.runID)
 
-	asgName, err := u.getAsgNameForCluster(u.cluster)
+	target, err := u.findUpgradeTarget()
 	if err != nil {
 		return err
 	}
-	u.logger.Printf("Found ASG: %s\n", asgName)
+	asgName, lt, ltData, latestImage := target.asgName, target.lt, target.ltData, target.latestImage
 
-	lt, ltData, err := u.getLaunchTemplateForASG(asgName)
+	release, err := u.acquireLock(asgName)
 	if err != nil {
 		return err
 	}
-	u.logger.Printf("Launch template: %s\n", *lt.LaunchTemplateName)
-	u.logger.Printf("Latest version: %d\n", *lt.LatestVersionNumber)
-	u.logger.Printf("Current image ID: %s\n", *ltData.ImageId)
+	defer release()
 
-	_, err = u.getImageByID(*ltData.ImageId, u.amiFilter)
+	outdatedFound, err := u.checkRunningInstances(*latestImage.ImageId, u.configuredInstanceTypes())
 	if err != nil {
-		return fmt.Errorf("launch template image name doesn't match the AMI Filter")
+		return err
 	}
 
-	latestImage, err := u.LatestAMI()
-	if err != nil {
+	if !(outdatedFound || target.isNewer || len(target.instanceTypeChanges) > 0 || u.forceReplacement) {
+		u.logger.Println("Upgrade not needed, cluster is already running the latest AMI")
+
+		// a run that didn't finish may have left scaling processes suspended
+		if err := u.restoreScalingProcesses(asgName); err != nil {
+			return err
+		}
+		return u.terminateOrphanedInstances(asgName)
+	}
+
+	if target.isNewer {
+		u.logger.Println("Latest image determined to be newer than image currently in use, proceeding with upgrade")
+	}
+
+	if err := u.logPreflight(); err != nil {
 		return err
 	}
-	u.logger.Printf("Latest image found: %s\n", *latestImage.ImageId)
 
-	current, err := u.getImageByID(*ltData.ImageId)
+	asg, err := u.getAsgByName(asgName)
 	if err != nil {
+		return fmt.Errorf("failed to get ASG by name: %s", err)
+	}
+
+	if err := u.checkDefaultVersionPolicy(asg); err != nil {
 		return err
 	}
 
-	isNewer, err := isNewerImage(current, latestImage)
-	if err != nil {
+	// record the ASG before instance type overrides change its policy, so that a failure can be undone
+	run := newUpgradeRun(asg, lt)
+
+	if err := u.applyInstanceTypeOverrides(asg); err != nil {
 		return err
 	}
 
-	oldImageFound, err := u.checkRunningInstances(*latestImage.ImageId)
+	overrideTemplates, err := u.getOverrideLaunchTemplates(asg, lt, latestImage)
 	if err != nil {
 		return err
 	}
-
-	if !(oldImageFound || isNewer || u.forceReplacement) {
-		u.logger.Println("Upgrade not needed, cluster is already running the latest AMI")
-		return u.terminateOrphanedInstances(asgName)
+	for _, o := range overrideTemplates {
+		run.defaultVersions[*o.lt.LaunchTemplateId] = aws.ToInt64(o.lt.DefaultVersionNumber)
+		run.latestVersions[*o.lt.LaunchTemplateId] = aws.ToInt64(o.lt.LatestVersionNumber)
 	}
 
-	if isNewer {
-		u.logger.Println("Latest image determined to be newer than image currently in use, proceeding with upgrade")
+	// prepare all the new launch template data first, so that problems are found before anything changes
+	prepared, err := u.prepareLaunchTemplateData(ltData, target.currentImage, latestImage, u.instanceType)
+	if err != nil {
+		return fmt.Errorf("launch template %s: %w", *lt.LaunchTemplateName, err)
 	}
+	prepared.logChanges(u.logger, *lt.LaunchTemplateName)
 
-	asg, err := u.getAsgByName(asgName)
-	if err != nil {
-		return fmt.Errorf("failed to get ASG by name: %s", err)
+	overridePrepared := make([]preparedLaunchTemplateData, len(overrideTemplates))
+	for i, o := range overrideTemplates {
+		overridePrepared[i], err = u.prepareLaunchTemplateData(o.ltData, o.image, latestImage, "")
+		if err != nil {
+			return fmt.Errorf("launch template %s: %w", *o.lt.LaunchTemplateName, err)
+		}
+		overridePrepared[i].logChanges(u.logger, *o.lt.LaunchTemplateName)
 	}
 
 	// get cluster list before new instances are added
@@ -272,56 +511,195 @@ func (u *Upgrader) UpgradeCluster() error {
 	}
 	u.logger.Printf("Existing instances in ASG: %s\n", strings.Join(originalInstanceIDs, ", "))
 
-	newLtv, err := u.newLaunchTemplateVersionWithNewImage(lt, ltData, latestImage)
+	batchSize, err := u.chooseBatchSize(asg, prepared.data, len(originalClusterInstances))
 	if err != nil {
 		return err
 	}
-	u.logger.Printf("New launch template version created: %d\n", *newLtv.VersionNumber)
+	batches := instanceBatches(originalClusterInstances, batchSize)
 
-	if err := u.updateAsgLaunchTemplate(asgName, newLtv); err != nil {
+	// keep scaling activity from changing the ASG's instances while they're replaced. If the processes
+	// can't be restored now, the next run restores them.
+	if err := u.suspendScalingProcesses(asg); err != nil {
 		return err
 	}
-	u.logger.Println("ASG updated to use new launch template version")
+	defer func() {
+		if u.lockErr() != nil {
+			u.logger.Println("Not restoring scaling processes because the upgrade lock was lost")
+			return
+		}
+		if err := u.restoreScalingProcesses(asgName); err != nil {
+			u.logger.Printf("Unable to restore scaling processes, they will be restored on the next run: %s", err)
+		}
+	}()
 
-	// detach and replace instances
-	if err := u.detachAndReplaceAsgInstances(asgName); err != nil {
-		return err
+	// from here on, a failure before any old instance is deregistered is undone according to the failure policy
+	abort := func(err error) error {
+		return u.abortUpgrade(run, err)
 	}
 
-	// watch ECS cluster for new EC2 instances to be registered
-	if err := u.waitForContainerInstanceCount(u.cluster, int(*asg.DesiredCapacity)*2); err != nil {
+	// tasks that fail on the instances added from here on count against the task failure threshold
+	u.taskFailures = newTaskFailureWatch(containerInstanceArns(originalClusterInstances))
+	defer func() {
+		u.taskFailures = nil
+	}()
+
+	newLtv, err := u.newLaunchTemplateVersionWithNewImage(lt, prepared)
+	if err != nil {
 		return err
 	}
+	run.newVersions = append(run.newVersions, newLtv)
+	u.logger.Printf("New launch template version created: %d\n", *newLtv.VersionNumber)
+
+	var overrideLtvs []*ec2types.LaunchTemplateVersion
+	for i, o := range overrideTemplates {
+		ltv, err := u.newLaunchTemplateVersionWithNewImage(o.lt, overridePrepared[i])
+		if err != nil {
+			return abort(err)
+		}
+		run.newVersions = append(run.newVersions, ltv)
+		u.logger.Printf("New version of override launch template %s created: %d\n", *o.lt.LaunchTemplateName, *ltv.VersionNumber)
+		overrideLtvs = append(overrideLtvs, ltv)
+	}
+
+	if err := u.updateAsgLaunchTemplate(asg, newLtv, overrideLtvs...); err != nil {
+		return abort(err)
+	}
+	u.logger.Println("ASG updated to use new launch template version")
+
+	// replace warm pool instances first, so the old image isn't brought back into service from the warm pool
+	run.warmPoolRefreshed = true
+	if err := u.refreshWarmPool(asgName); err != nil {
+		return abort(err)
+	}
+
+	// Replace instances one batch at a time. Detaching a batch makes the ASG launch its replacements, then
+	// the detached instances are terminated one at a time while waiting for services to stabilize after each.
+	asgInstanceIDs := make([]string, len(asg.Instances))
+	for i, instance := range asg.Instances {
+		asgInstanceIDs[i] = *instance.InstanceId
+	}
+	replaced := 0
+	// skipped instances stay registered with the cluster while they drain
+	skipped := 0
+	for n, batch := range batches {
+		var detach []string
+		for _, i := range batch {
+			if internal.IsStringInSlice(*i.Ec2InstanceId, asgInstanceIDs) {
+				detach = append(detach, *i.Ec2InstanceId)
+			}
+		}
+		// instances in the ASG that never registered with the cluster are replaced with the last batch
+		if n == len(batches)-1 {
+			for _, id := range asgInstanceIDs {
+				if !internal.IsStringInSlice(id, originalInstanceIDs) {
+					detach = append(detach, id)
+				}
+			}
+		}
+		replaced += len(detach)
+		run.replacedInstanceIDs = append(run.replacedInstanceIDs, detach...)
+
+		u.logger.Printf("Replacing batch %d of %d: %s\n", n+1, len(batches), strings.Join(detach, ", "))
+		inService := min(int32(replaced), *asg.DesiredCapacity)
+		if u.keepInstancesInASG {
+			err = u.scaleOutAsgInstances(asgName, detach, *latestImage.ImageId, inService)
+		} else {
+			err = u.detachAndReplaceAsgInstances(asgName, detach, *latestImage.ImageId, inService)
+		}
+		if err != nil {
+			return abort(err)
+		}
+
+		// watch ECS cluster for new EC2 instances to be registered
+		if err := u.waitForContainerInstanceCount(u.cluster, len(originalClusterInstances)+len(detach)+skipped); err != nil {
+			return abort(err)
+		}
+
+		// make sure the new instances can take over before any old instance is retired
+		if err := u.verifyNewInstances(originalInstanceIDs, *latestImage.ImageId); err != nil {
+			return abort(err)
+		}
+		if err := u.checkTaskFailures(); err != nil {
+			return abort(err)
+		}
+
+		for n, i := range batch {
+			if err := u.lockErr(); err != nil {
+				return err
+			}
+			if err := u.waitForResourceFit(*i.Ec2InstanceId, *i.ContainerInstanceArn, containerInstanceArns(batch[n+1:])); err != nil {
+				return abort(err)
+			}
+
+			// once an old instance is drained or deregistered, a failure is no longer undone
+			run.deregistered = true
+			remove, err := u.clearStandaloneTasks(*i.Ec2InstanceId, *i.ContainerInstanceArn)
+			if err != nil {
+				return err
+			}
+			// a skipped instance isn't retired, so a rollback reattaches it
+			if !remove {
+				skipped++
+				continue
+			}
+
+			if err := u.deregisterClusterInstance(*i.ContainerInstanceArn, u.cluster); err != nil {
+				return err
+			}
+			run.retiredInstanceIDs = append(run.retiredInstanceIDs, *i.Ec2InstanceId)
+
+			if err := u.safeTerminateInstance(*i.Ec2InstanceId, false); err != nil {
+				return abort(err)
+			}
+		}
+	}
 
 	clusterInstances, err := u.getInstanceIDsForCluster(u.cluster)
 	if err != nil {
 		return err
 	}
+	u.logger.Printf("New instances in ASG: %s\n", strings.Join(clusterInstances, ", "))
 
-	var newInstances []string
-	for _, c := range clusterInstances {
-		if !internal.IsStringInSlice(c, originalInstanceIDs) {
-			newInstances = append(newInstances, c)
-		}
+	// instances that came from a warm pool may still run an old image, so replace them as well
+	staleInstances, err := u.findStaleNewInstances(originalInstanceIDs, *latestImage.ImageId)
+	if err != nil {
+		return err
 	}
-	u.logger.Printf("New instances in ASG: %s\n", strings.Join(newInstances, ", "))
+	for n, i := range staleInstances {
+		if err := u.lockErr(); err != nil {
+			return abort(err)
+		}
+		if err := u.waitForResourceFit(*i.Ec2InstanceId, *i.ContainerInstanceArn,
+			containerInstanceArns(staleInstances[n+1:])); err != nil {
+			return abort(err)
+		}
+
+		remove, err := u.clearStandaloneTasks(*i.Ec2InstanceId, *i.ContainerInstanceArn)
+		if err != nil {
+			return abort(err)
+		}
+		if !remove {
+			continue
+		}
 
-	// Terminate existing instances one at a time while waiting for services to stabilize after each
-	for _, i := range originalClusterInstances {
 		if err := u.deregisterClusterInstance(*i.ContainerInstanceArn, u.cluster); err != nil {
-			return err
+			return abort(err)
 		}
 
-		if err := u.safeTerminateInstance(*i.Ec2InstanceId); err != nil {
-			return err
+		if err := u.safeTerminateInstance(*i.Ec2InstanceId, true); err != nil {
+			return abort(err)
 		}
 	}
 
 	if err := u.terminateOrphanedInstances(asgName); err != nil {
-		return err
+		return abort(err)
 	}
 
-	if err := u.cleanupOldLaunchTemplates(); err != nil {
+	templateIDs := []string{*lt.LaunchTemplateId}
+	for _, o := range overrideTemplates {
+		templateIDs = append(templateIDs, *o.lt.LaunchTemplateId)
+	}
+	if err := u.cleanupOldLaunchTemplates(templateIDs); err != nil {
 		return err
 	}
 
@@ -330,6 +708,92 @@ func (u *Upgrader) UpgradeCluster() error {
 	return nil
 }
 
+// upgradeTarget holds the ASG, launch template and images involved in upgrading the configured cluster
+type upgradeTarget struct {
+	asgName             string
+	lt                  *ec2types.LaunchTemplate
+	ltData              *ec2types.ResponseLaunchTemplateData
+	currentImage        ec2types.Image
+	latestImage         ec2types.Image
+	isNewer             bool
+	instanceTypeChanges []string
+}
+
+// findUpgradeTarget looks up the cluster's ASG and launch template, and compares the image in use
+// with the latest image for the configured AMI filter. If the AMI upgrade is skipped, the current image
+// is used as the latest image.
+func (u *Upgrader) findUpgradeTarget() (upgradeTarget, error) {
+	asgName, err := u.getAsgNameForCluster(u.cluster)
+	if err != nil {
+		return upgradeTarget{}, err
+	}
+	u.logger.Printf("Found ASG: %s\n", asgName)
+
+	lt, ltData, err := u.getLaunchTemplateForASG(asgName)
+	if err != nil {
+		return upgradeTarget{}, err
+	}
+	u.logger.Printf("Launch template: %s\n", *lt.LaunchTemplateName)
+	u.logger.Printf("Latest version: %d\n", *lt.LatestVersionNumber)
+	u.logger.Printf("Current image ID: %s\n", *ltData.ImageId)
+
+	// a new instance type may need an AMI for another architecture, so the filter is expected to change
+	if !u.changesInstanceTypes() {
+		_, err = u.getImageByID(*ltData.ImageId, u.amiFilter)
+		if err != nil {
+			return upgradeTarget{}, fmt.Errorf("launch template image name doesn't match the AMI Filter")
+		}
+	}
+
+	current, err := u.getImageByID(*ltData.ImageId)
+	if err != nil {
+		return upgradeTarget{}, err
+	}
+
+	latestImage := current
+	if !u.skipAMIUpgrade {
+		latestImage, err = u.LatestAMI()
+		if err != nil {
+			return upgradeTarget{}, err
+		}
+		u.logger.Printf("Latest image found: %s\n", *latestImage.ImageId)
+	}
+
+	isNewer, err := isNewerImage(current, latestImage)
+	if err != nil {
+		return upgradeTarget{}, err
+	}
+
+	target := upgradeTarget{
+		asgName:      asgName,
+		lt:           lt,
+		ltData:       ltData,
+		currentImage: current,
+		latestImage:  latestImage,
+		isNewer:      isNewer,
+	}
+
+	if u.changesInstanceTypes() {
+		if err := u.checkInstanceTypeArchitecture(latestImage); err != nil {
+			return upgradeTarget{}, err
+		}
+
+		asg, err := u.getAsgByName(asgName)
+		if err != nil {
+			return upgradeTarget{}, err
+		}
+		if err := u.checkInstanceTypeForPolicy(asg); err != nil {
+			return upgradeTarget{}, err
+		}
+		target.instanceTypeChanges = u.instanceTypeChanges(asg, ltData)
+		for _, c := range target.instanceTypeChanges {
+			u.logger.Printf("Instance type change: %s\n", c)
+		}
+	}
+
+	return target, nil
+}
+
 func (u *Upgrader) getAsgNameForCluster(cluster string) (string, error) {
 	instanceIDs, err := u.getInstanceIDsForCluster(cluster)
 	if err != nil {
@@ -403,69 +867,91 @@ func (u *Upgrader) getInstanceListForCluster(cluster string) ([]ecsTypes.Contain
 }
 
 func (u *Upgrader) getLaunchTemplateForASG(asgName string) (*ec2types.LaunchTemplate, *ec2types.ResponseLaunchTemplateData, error) {
-	input := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []string{
-			asgName,
-		},
+	group, err := u.getAsgByName(asgName)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	result, err := u.asgClient.DescribeAutoScalingGroups(context.Background(), input)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to describe auto-scaling groups: %w", err)
+	spec := asgLaunchTemplateSpec(group)
+	if spec == nil && group.LaunchConfigurationName != nil {
+		return nil, nil, fmt.Errorf("ASG %s uses launch configuration %s, which must be migrated to a launch template first",
+			asgName, *group.LaunchConfigurationName)
+	}
+	if spec == nil {
+		return nil, nil, fmt.Errorf("ASG %s has no launch template", asgName)
 	}
 
-	var group asgTypes.AutoScalingGroup
+	lt, err := u.describeLaunchTemplate(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w for ASG %s", err, asgName)
+	}
 
-	// we should only get one ASG back, but just to be safe, loop through results and look for specific match
-	for _, a := range result.AutoScalingGroups {
-		if *a.AutoScalingGroupName == asgName {
-			group = a
-			break
-		}
+	// use the version the ASG launches instances from, which isn't necessarily the latest
+	version := aws.ToString(spec.Version)
+	if version == "" {
+		version = "$Default"
+	}
+	ltData, err := u.getLaunchTemplateData(lt, version)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// if no matching group was found, return err
-	if group.LaunchTemplate == nil {
-		return nil, nil, fmt.Errorf("ASG %s has no launch template", asgName)
+	return lt, ltData, nil
+}
+
+// asgLaunchTemplateSpec returns the ASG's launch template specification, which is found in the mixed
+// instances policy for ASGs that have one. Nil is returned if the ASG does not use a launch template.
+func asgLaunchTemplateSpec(group *asgTypes.AutoScalingGroup) *asgTypes.LaunchTemplateSpecification {
+	if group.LaunchTemplate != nil {
+		return group.LaunchTemplate
+	}
+	if group.MixedInstancesPolicy != nil && group.MixedInstancesPolicy.LaunchTemplate != nil {
+		return group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
 	}
+	return nil
+}
 
-	ltInput := &ec2.DescribeLaunchTemplatesInput{
-		LaunchTemplateNames: []string{
-			*group.LaunchTemplate.LaunchTemplateName,
-		},
+// describeLaunchTemplate finds the launch template identified by an ASG launch template specification,
+// which may use either the launch template ID or name
+func (u *Upgrader) describeLaunchTemplate(spec *asgTypes.LaunchTemplateSpecification) (*ec2types.LaunchTemplate, error) {
+	ltInput := &ec2.DescribeLaunchTemplatesInput{}
+	if spec.LaunchTemplateId != nil {
+		ltInput.LaunchTemplateIds = []string{*spec.LaunchTemplateId}
+	} else {
+		ltInput.LaunchTemplateNames = []string{aws.ToString(spec.LaunchTemplateName)}
 	}
 
 	ltResult, err := u.ec2Client.DescribeLaunchTemplates(context.Background(), ltInput)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to describe launch templates: %w", err)
+		return nil, fmt.Errorf("failed to describe launch templates: %w", err)
 	}
 
-	var lt *ec2types.LaunchTemplate
-
 	// we should only get one LT back, but just to be safe, loop through results and look for specific match
 	for _, l := range ltResult.LaunchTemplates {
-		if *l.LaunchTemplateName == *group.LaunchTemplate.LaunchTemplateName {
-			lt = &l
-			break
+		if aws.ToString(spec.LaunchTemplateId) == *l.LaunchTemplateId ||
+			aws.ToString(spec.LaunchTemplateName) == *l.LaunchTemplateName {
+			return &l, nil
 		}
 	}
 
-	// if no matching LT was found, return err
-	if lt == nil {
-		return nil, nil, fmt.Errorf("unable to find a launch template by name %s for ASG %s",
-			*group.LaunchTemplate.LaunchTemplateName, asgName)
-	}
+	return nil, fmt.Errorf("unable to find a launch template by name %s", aws.ToString(spec.LaunchTemplateName))
+}
 
+// getLaunchTemplateData returns the launch template data for the given version of the launch template
+func (u *Upgrader) getLaunchTemplateData(lt *ec2types.LaunchTemplate, version string) (*ec2types.ResponseLaunchTemplateData, error) {
 	ltdInput := ec2.DescribeLaunchTemplateVersionsInput{
 		LaunchTemplateId: lt.LaunchTemplateId,
-		Versions:         []string{"$Latest"},
+		Versions:         []string{version},
 	}
 	ltv, err := u.ec2Client.DescribeLaunchTemplateVersions(context.Background(), &ltdInput)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	if len(ltv.LaunchTemplateVersions) == 0 {
+		return nil, fmt.Errorf("launch template %s has no version %s", *lt.LaunchTemplateName, version)
 	}
 
-	return lt, ltv.LaunchTemplateVersions[0].LaunchTemplateData, nil
+	return ltv.LaunchTemplateVersions[0].LaunchTemplateData, nil
 }
 
 func (u *Upgrader) getImageByID(imageID string, filters ...string) (ec2types.Image, error) {
@@ -496,32 +982,12 @@ func (u *Upgrader) getImageByID(imageID string, filters ...string) (ec2types.Ima
 }
 
 func (u *Upgrader) newLaunchTemplateVersionWithNewImage(lt *ec2types.LaunchTemplate,
-	ltd *ec2types.ResponseLaunchTemplateData, image ec2types.Image) (*ec2types.LaunchTemplateVersion, error) {
-
-	newLtd, err := makeLaunchTemplateDataRequest(ltd)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create a new launch template version, %w", err)
-	}
-
-	newLtd.ImageId = image.ImageId
-
-	// KernelId and RamdiskId must be updated anytime a the ImageId is updated
-	newLtd.KernelId = image.KernelId
-	newLtd.RamDiskId = image.RamdiskId
-
-	// need to nil out snapshot ids of block devices so they don't reference old AMI
-	for _, b := range newLtd.BlockDeviceMappings {
-		b.Ebs.SnapshotId = nil
-	}
-
-	// If newLtv has an SSH key name and it's empty, change to nil as empty is not valid
-	if newLtd.KeyName != nil && *newLtd.KeyName == "" {
-		newLtd.KeyName = nil
-	}
+	prepared preparedLaunchTemplateData) (*ec2types.LaunchTemplateVersion, error) {
 
 	newLtv := ec2.CreateLaunchTemplateVersionInput{
 		LaunchTemplateId:   lt.LaunchTemplateId,
-		LaunchTemplateData: newLtd,
+		LaunchTemplateData: prepared.data,
+		VersionDescription: aws.String(u.provenance(prepared.oldImage, prepared.newImage).Description()),
 	}
 
 	out, err := u.ec2Client.CreateLaunchTemplateVersion(context.Background(), &newLtv)
@@ -540,58 +1006,130 @@ func makeLaunchTemplateDataRequest(in *ec2types.ResponseLaunchTemplateData) (*ec
 	return &out, nil
 }
 
-func (u *Upgrader) updateAsgLaunchTemplate(asgName string, v *ec2types.LaunchTemplateVersion) error {
+// updateAsgLaunchTemplate points the ASG at the new launch template version. The ASG keeps its style of
+// version reference: "$Latest" and "$Default" are left as they are, and a pinned version number is replaced
+// by the new version number. The new version becomes the template's default according to the default
+// version policy. For ASGs with a mixed instances policy, the launch template in the policy is updated
+// instead, along with any overrides that have their own new launch template versions. The ASG is expected to
+// have the configured instance type overrides applied already.
+func (u *Upgrader) updateAsgLaunchTemplate(asg *asgTypes.AutoScalingGroup, v *ec2types.LaunchTemplateVersion,
+	overrideVersions ...*ec2types.LaunchTemplateVersion) error {
+
+	asgName := *asg.AutoScalingGroupName
+	versions := append([]*ec2types.LaunchTemplateVersion{v}, overrideVersions...)
+
 	updateInput := &autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(asgName),
-		LaunchTemplate: &asgTypes.LaunchTemplateSpecification{
+	}
+	if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		updateInput.MixedInstancesPolicy = mixedInstancesPolicyWithVersions(asg.MixedInstancesPolicy, versions...)
+	} else {
+		updateInput.LaunchTemplate = &asgTypes.LaunchTemplateSpecification{
 			LaunchTemplateId: v.LaunchTemplateId,
-			Version:          aws.String("$Latest"),
-		},
+			Version:          aws.String(versionReference(asg.LaunchTemplate.Version, *v.VersionNumber)),
+		}
 	}
 	if _, err := u.asgClient.UpdateAutoScalingGroup(context.Background(), updateInput); err != nil {
 		return fmt.Errorf("unable to update ASG %s to use launch template %s version %d, error: %w",
 			asgName, *v.LaunchTemplateName, *v.VersionNumber, err)
 	}
 
-	in := &ec2.ModifyLaunchTemplateInput{
-		DefaultVersion:   aws.String(fmt.Sprintf("%d", *v.VersionNumber)),
-		LaunchTemplateId: v.LaunchTemplateId,
+	usesDefault := templatesReferencingDefault(asg)
+	for _, version := range versions {
+		if u.defaultVersionPolicy != DefaultVersionPolicyAlways && !usesDefault[*version.LaunchTemplateId] {
+			continue
+		}
+
+		in := &ec2.ModifyLaunchTemplateInput{
+			DefaultVersion:   aws.String(fmt.Sprintf("%d", *version.VersionNumber)),
+			LaunchTemplateId: version.LaunchTemplateId,
+		}
+		if _, err := u.ec2Client.ModifyLaunchTemplate(context.Background(), in); err != nil {
+			return fmt.Errorf("failed to modify launch template: %w", err)
+		}
+		u.logger.Printf("Launch template %s default version set to %d\n", *version.LaunchTemplateName, *version.VersionNumber)
+	}
+	return nil
+}
+
+// checkDefaultVersionPolicy returns an error if the ASG refers to the default version of a launch template
+// but the policy doesn't allow changing the default, since the upgrade would have no effect
+func (u *Upgrader) checkDefaultVersionPolicy(asg *asgTypes.AutoScalingGroup) error {
+	if u.defaultVersionPolicy != DefaultVersionPolicyNever {
+		return nil
 	}
-	if _, err := u.ec2Client.ModifyLaunchTemplate(context.Background(), in); err != nil {
-		return fmt.Errorf("failed to modify launch template: %w", err)
+	if len(templatesReferencingDefault(asg)) > 0 {
+		return fmt.Errorf("ASG %s uses the $Default launch template version, which the %q default version policy "+
+			"does not allow to be changed", *asg.AutoScalingGroupName, u.defaultVersionPolicy)
 	}
 	return nil
 }
 
-func (u *Upgrader) detachAndReplaceAsgInstances(asgName string) error {
-	asg, err := u.getAsgByName(asgName)
-	if err != nil {
-		return fmt.Errorf("error trying to get ASG by name: %s", err)
+// templatesReferencingDefault returns the IDs and names of the launch templates that the ASG refers to by
+// their default version, either directly or through its mixed instances policy
+func templatesReferencingDefault(asg *asgTypes.AutoScalingGroup) map[string]bool {
+	templates := map[string]bool{}
+	add := func(spec *asgTypes.LaunchTemplateSpecification) {
+		if spec == nil {
+			return
+		}
+		if v := aws.ToString(spec.Version); v != "" && v != "$Default" {
+			return
+		}
+		if spec.LaunchTemplateId != nil {
+			templates[*spec.LaunchTemplateId] = true
+		}
+		if spec.LaunchTemplateName != nil {
+			templates[*spec.LaunchTemplateName] = true
+		}
+	}
+
+	add(asgLaunchTemplateSpec(asg))
+	if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		for _, o := range asg.MixedInstancesPolicy.LaunchTemplate.Overrides {
+			add(o.LaunchTemplateSpecification)
+		}
 	}
+	return templates
+}
 
-	var existingInstances []string
-	for _, i := range asg.Instances {
-		existingInstances = append(existingInstances, *i.InstanceId)
+// versionReference returns the ASG launch template version reference for a new version, in the same style
+// as the current reference
+func versionReference(current *string, newVersion int64) string {
+	switch aws.ToString(current) {
+	case "$Latest":
+		return "$Latest"
+	case "$Default", "":
+		return "$Default"
+	}
+	return fmt.Sprintf("%d", newVersion)
+}
+
+// detachAndReplaceAsgInstances tags the instances for termination and detaches them from the ASG, which
+// launches replacements. It then waits until the ASG has the given number of in service instances running
+// the new image.
+func (u *Upgrader) detachAndReplaceAsgInstances(asgName string, instanceIDs []string, imageID string, inService int32) error {
+	if len(instanceIDs) == 0 {
+		return nil
 	}
 
 	u.logger.Println("Tagging existing instances for later verification that they have been terminated")
-	if err := u.tagInstancesForTermination(asgName, existingInstances); err != nil {
+	if err := u.tagInstancesForTermination(asgName, instanceIDs); err != nil {
 		return err
 	}
 
-	u.logger.Printf("Found %v existing instances in ASG", len(existingInstances))
-	u.logger.Println("Detaching and replacing existing instances...")
-	_, err = u.asgClient.DetachInstances(context.Background(), &autoscaling.DetachInstancesInput{
+	u.logger.Printf("Detaching and replacing %v existing instances...", len(instanceIDs))
+	_, err := u.asgClient.DetachInstances(context.Background(), &autoscaling.DetachInstancesInput{
 		AutoScalingGroupName:           &asgName,
-		InstanceIds:                    existingInstances,
+		InstanceIds:                    instanceIDs,
 		ShouldDecrementDesiredCapacity: aws.Bool(false),
 	})
 	if err != nil {
 		return fmt.Errorf("error trying to detach existing instances: %s", err)
 	}
 
-	u.logger.Printf("Existing instances detached, new instances starting soon, will wait up to %s", u.pollingTimeout)
-	return u.waitForNewAsgInstances(asgName)
+	u.logger.Printf("Existing instances detached, new instances starting soon, will wait up to %s", u.inServiceTimeout)
+	return u.waitForNewAsgInstances(asgName, imageID, inService)
 }
 
 func (u *Upgrader) getAsgByName(asgName string) (*asgTypes.AutoScalingGroup, error) {
@@ -647,7 +1185,10 @@ func (u *Upgrader) tagInstancesForTermination(asgName string, instanceIDs []stri
 	return nil
 }
 
-func (u *Upgrader) waitForNewAsgInstances(asgName string) error {
+// waitForNewAsgInstances waits for the ASG to have at least the given number of in service instances
+// running the given image. Instances that run another image, for example from a warm pool that had not yet
+// been refreshed, are not counted.
+func (u *Upgrader) waitForNewAsgInstances(asgName, imageID string, want int32) error {
 	input := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []string{asgName},
 	}
@@ -655,6 +1196,10 @@ func (u *Upgrader) waitForNewAsgInstances(asgName string) error {
 	// the provided InService waiter in SDK doesn't seem to work. Had to write an overriding Retryable
 	// feature to get desired results.
 	waiter := autoscaling.NewGroupInServiceWaiter(u.asgClient, func(options *autoscaling.GroupInServiceWaiterOptions) {
+		if u.inServiceInterval > 0 {
+			options.MinDelay = u.inServiceInterval
+			options.MaxDelay = u.inServiceInterval
+		}
 		options.Retryable = func(ctx context.Context, input *autoscaling.DescribeAutoScalingGroupsInput, output *autoscaling.DescribeAutoScalingGroupsOutput, err error) (bool, error) {
 			if output == nil {
 				return true, nil
@@ -666,19 +1211,35 @@ func (u *Upgrader) waitForNewAsgInstances(asgName string) error {
 					continue
 				}
 
-				inServiceCount := int32(0)
+				var inService []string
 				for _, i := range a.Instances {
 					if i.LifecycleState == asgTypes.LifecycleStateInService {
+						inService = append(inService, *i.InstanceId)
+					}
+				}
+
+				images, err := u.getInstanceImages(inService)
+				if err != nil {
+					u.logger.Printf("unable to check images of in service instances: %s", err)
+					return true, nil
+				}
+				inServiceCount := int32(0)
+				for _, id := range inService {
+					if images[id] == imageID {
 						inServiceCount++
 					}
 				}
 
-				// if we have at least as many in service instances as the minimum size of the ASG, consider it ready
-				if inServiceCount >= *a.MinSize {
+				// if we have at least as many in service instances as we're waiting for, consider it ready
+				if inServiceCount >= want {
 					return false, nil
 				}
 
-				u.logger.Printf("ASG not ready yet, min size = %v, currently in service = %v", *a.MinSize, inServiceCount)
+				u.logger.Printf("ASG not ready yet, waiting for %v, currently in service with image %s = %v", want, imageID, inServiceCount)
+				// instances only go in service once their launch lifecycle hooks have completed
+				if waiting := lifecycleHookWaits(a.Instances); waiting > 0 {
+					u.logger.Printf("%d instances are waiting on launch lifecycle hooks", waiting)
+				}
 				return true, nil
 			}
 
@@ -686,7 +1247,10 @@ func (u *Upgrader) waitForNewAsgInstances(asgName string) error {
 			return true, nil
 		}
 	})
-	if err := waiter.Wait(context.Background(), input, u.pollingTimeout); err != nil {
+	if err := waiter.Wait(u.lockContext(), input, u.inServiceTimeout); err != nil {
+		if lockErr := u.lockErr(); lockErr != nil {
+			return lockErr
+		}
 		return fmt.Errorf("error waiting for ASG to become in service after detaching instances: %s", err)
 	}
 
@@ -702,10 +1266,12 @@ func (u *Upgrader) waitForContainerInstanceCount(cluster string, desired int) er
 	u.logger.Printf("Waiting for cluster %s instances...", cluster)
 	startTime := time.Now()
 	for {
-		if time.Since(startTime) >= u.pollingTimeout {
-			return fmt.Errorf("timeout while waiting for cluster %s to have %v instances", cluster, desired)
+		if time.Since(startTime) >= u.registrationTimeout {
+			return fmt.Errorf("timeout while waiting for cluster %s to have at least %v instances", cluster, desired)
+		}
+		if err := u.pause(u.registrationInterval); err != nil {
+			return err
 		}
-		time.Sleep(u.pollingInterval)
 
 		result, err := u.ecsClient.DescribeClusters(context.Background(), input)
 		if err != nil {
@@ -717,11 +1283,11 @@ func (u *Upgrader) waitForContainerInstanceCount(cluster string, desired int) er
 			if *c.ClusterName != cluster {
 				continue
 			}
-			if c.RegisteredContainerInstancesCount == int32(desired) {
+			if c.RegisteredContainerInstancesCount >= int32(desired) {
 				u.logger.Printf("Cluster %s now has %v registered instances.", cluster, c.RegisteredContainerInstancesCount)
 				return nil
 			}
-			u.logger.Printf("Still waiting for cluster %s to have %v registered instances, currently has %v", cluster, desired, c.RegisteredContainerInstancesCount)
+			u.logger.Printf("Still waiting for cluster %s to have at least %v registered instances, currently has %v", cluster, desired, c.RegisteredContainerInstancesCount)
 		}
 	}
 }
@@ -742,7 +1308,10 @@ func (u *Upgrader) deregisterClusterInstance(clusterInstanceArn, cluster string)
 	return nil
 }
 
-func (u *Upgrader) safeTerminateInstance(instanceId string) error {
+// safeTerminateInstance terminates the instance once the cluster is stable. When instances are kept in the
+// ASG, an instance that is still in the ASG is terminated through it so that lifecycle hooks run, and the ASG
+// only launches a replacement if replace is set.
+func (u *Upgrader) safeTerminateInstance(instanceId string, replace bool) error {
 	// before terminating instance ensure cluster is stable
 	u.logger.Println("Waiting for services to stabilize...")
 	if err := u.waitForStableCluster(); err != nil {
@@ -750,7 +1319,18 @@ func (u *Upgrader) safeTerminateInstance(instanceId string) error {
 	}
 	u.logger.Printf("Services stable, will terminate instance %s now", instanceId)
 
-	if err := u.terminateInstances([]string{instanceId}); err != nil {
+	var asgInstance *asgTypes.AutoScalingInstanceDetails
+	if u.keepInstancesInASG {
+		var err error
+		if asgInstance, err = u.describeAsgInstance(instanceId); err != nil {
+			return err
+		}
+	}
+	if asgInstance != nil {
+		if err := u.terminateAsgInstance(instanceId, !replace); err != nil {
+			return err
+		}
+	} else if err := u.terminateInstances([]string{instanceId}); err != nil {
 		return err
 	}
 
@@ -771,17 +1351,23 @@ func (u *Upgrader) waitForStableCluster() error {
 
 	startTime := time.Now()
 	for {
-		if stableCheckCount >= MinimumIntervalsForStable {
-			// we've seen zero pending tasks for MinimumIntervalsForStable iterations,
+		if stableCheckCount >= u.minimumIntervalsForStable {
+			// we've seen zero pending tasks for minimumIntervalsForStable iterations,
 			// as extra safety precaution make sure there are no pending or incomplete deployments
 			u.logger.Println("Waiting for all service deployments to complete...")
 			return u.waitForCompletedDeployments()
 		}
 
-		if time.Since(startTime) >= u.pollingTimeout {
+		if time.Since(startTime) >= u.stabilityTimeout {
 			return fmt.Errorf("timeout while waiting for cluster to stabilize")
 		}
-		time.Sleep(u.pollingInterval)
+		if err := u.pause(u.stabilityInterval); err != nil {
+			return err
+		}
+
+		if err := u.checkTaskFailures(); err != nil {
+			return err
+		}
 
 		result, err := u.ecsClient.DescribeClusters(context.Background(), input)
 		if err != nil {
@@ -796,7 +1382,7 @@ func (u *Upgrader) waitForStableCluster() error {
 
 			if c.PendingTasksCount == 0 {
 				stableCheckCount++
-				u.logger.Printf("Cluster appears to be stable, iteration count %v of %v", stableCheckCount, MinimumIntervalsForStable)
+				u.logger.Printf("Cluster appears to be stable, iteration count %v of %v", stableCheckCount, u.minimumIntervalsForStable)
 				continue
 			}
 			stableCheckCount = 0
@@ -842,10 +1428,16 @@ func (u *Upgrader) waitForCompletedDeployments() error {
 
 	loop:
 		for {
-			if time.Since(startTime) > u.pollingTimeout {
+			if time.Since(startTime) > u.deploymentTimeout {
 				return fmt.Errorf("timeout while waiting for completed deployments")
 			}
-			time.Sleep(u.pollingInterval)
+			if err := u.pause(u.deploymentInterval); err != nil {
+				return err
+			}
+
+			if err := u.checkTaskFailures(); err != nil {
+				return err
+			}
 
 			result, err := u.ecsClient.DescribeServices(context.Background(), input)
 			if err != nil {
@@ -939,8 +1531,34 @@ func (u *Upgrader) terminateOrphanedInstances(asgName string) error {
 
 	u.logger.Printf("Found orphaned instances: %s\n", strings.Join(orphans, ", "))
 	u.logger.Printf("Will terminate one at a time and wait for steady state\n")
+
+	// orphans that are still registered with the cluster may have tasks that need to move
+	containerInstances, err := u.getInstanceListForCluster(u.cluster)
+	if err != nil {
+		return err
+	}
+	var orphanArns []string
 	for _, id := range orphans {
-		if err := u.safeTerminateInstance(id); err != nil {
+		if arn := containerInstanceArnForInstance(containerInstances, id); arn != "" {
+			orphanArns = append(orphanArns, arn)
+		}
+	}
+	for _, id := range orphans {
+		if arn := containerInstanceArnForInstance(containerInstances, id); arn != "" {
+			if err := u.waitForResourceFit(id, arn, orphanArns); err != nil {
+				return err
+			}
+
+			remove, err := u.clearStandaloneTasks(id, arn)
+			if err != nil {
+				return err
+			}
+			if !remove {
+				continue
+			}
+		}
+
+		if err := u.safeTerminateInstance(id, false); err != nil {
 			return err
 		}
 	}
@@ -1000,44 +1618,18 @@ func (u *Upgrader) findDetachedButRunningInstances(asgName string) ([]string, er
 	return orphanInstances, nil
 }
 
-func (u *Upgrader) cleanupOldLaunchTemplates() error {
-	input := &ec2.DescribeLaunchTemplatesInput{
-		MaxResults: aws.Int32(100),
-	}
-
-	var relevantTemplates []ec2types.LaunchTemplate
-	paginator := ec2.NewDescribeLaunchTemplatesPaginator(u.ec2Client, input)
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.Background())
-		if err != nil {
-			return fmt.Errorf("error retrieving page of launch templates: %s", err)
-		}
-		for _, lt := range page.LaunchTemplates {
-			if strings.HasPrefix(*lt.LaunchTemplateName, u.launchTemplateNamePrefix) {
-				relevantTemplates = append(relevantTemplates, lt)
-			}
-		}
-	}
-
-	if len(relevantTemplates) == 0 || len(relevantTemplates) <= u.launchTemplateLimit {
-		return nil
-	}
-	u.logger.Printf("Found %v launch templates with prefix %s. Configured to only keep %v, will delete oldest revisions",
-		len(relevantTemplates), u.launchTemplateNamePrefix, u.launchTemplateLimit)
-
-	versions, err := u.getTemplateVersions(relevantTemplates)
+// cleanupOldLaunchTemplates deletes the versions of the given launch templates beyond the retention settings
+func (u *Upgrader) cleanupOldLaunchTemplates(templateIDs []string) error {
+	versions, err := u.findLaunchTemplateVersionsToDelete(templateIDs, false)
 	if err != nil {
 		return err
 	}
 
-	// sort launch template versions newest to oldest
-	reverseSortLaunchTemplateVersions(versions)
-
-	for i := u.launchTemplateLimit; i < len(versions); i++ {
-		versionString := fmt.Sprintf("%d", *versions[i].VersionNumber)
-		if err := u.deleteLaunchTemplateVersion(*versions[i].LaunchTemplateName, versionString); err != nil {
+	for _, v := range versions {
+		versionString := fmt.Sprintf("%d", *v.VersionNumber)
+		if err := u.deleteLaunchTemplateVersion(*v.LaunchTemplateName, versionString); err != nil {
 			return fmt.Errorf("error deleting launch template %s version %d: %w",
-				*versions[i].LaunchTemplateName, *versions[i].VersionNumber, err)
+				*v.LaunchTemplateName, *v.VersionNumber, err)
 		}
 	}
 
@@ -1074,6 +1666,37 @@ func isNewerImage(first, second ec2types.Image) (bool, error) {
 	return secondTime.After(firstTime), nil
 }
 
+// reverseSortImages sorts images newest to oldest by creation date
+func reverseSortImages(images []ec2types.Image) error {
+	created := make(map[string]time.Time, len(images))
+	for _, img := range images {
+		t, err := time.Parse(time.RFC3339, *img.CreationDate)
+		if err != nil {
+			return err
+		}
+		created[*img.ImageId] = t
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		return created[*images[i].ImageId].After(created[*images[j].ImageId])
+	})
+	return nil
+}
+
+// AMIUsage pairs each image with the clusters whose launch templates currently use it
+func AMIUsage(images []ec2types.Image, clusters []ClusterMeta) []AMIMeta {
+	usage := make([]AMIMeta, len(images))
+	for i, img := range images {
+		usage[i].Image = img
+		for _, c := range clusters {
+			if c.Image.ImageId != nil && *c.Image.ImageId == *img.ImageId {
+				usage[i].Clusters = append(usage[i].Clusters, *c.Cluster.ClusterName)
+			}
+		}
+	}
+	return usage
+}
+
 func reverseSortLaunchTemplateVersions(ltv []ec2types.LaunchTemplateVersion) {
 	sort.SliceStable(ltv, func(i, j int) bool {
 		return ltv[i].CreateTime.UnixNano() > ltv[j].CreateTime.UnixNano()
@@ -1084,19 +1707,23 @@ func (u *Upgrader) getTemplateVersions(templates []ec2types.LaunchTemplate) (ver
 	for _, t := range templates {
 		in := ec2.DescribeLaunchTemplateVersionsInput{
 			LaunchTemplateId: t.LaunchTemplateId,
+			MaxResults:       aws.Int32(200),
 		}
-		v, err := u.ec2Client.DescribeLaunchTemplateVersions(context.Background(), &in)
-		if err != nil {
-			return nil, err
+		paginator := ec2.NewDescribeLaunchTemplateVersionsPaginator(u.ec2Client, &in)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, page.LaunchTemplateVersions...)
 		}
-		versions = append(versions, v.LaunchTemplateVersions...)
 	}
 	return
 }
 
 // checkRunningInstances looks at the instances in the cluster and returns true if any of the instance images
-// are older than the latest
-func (u *Upgrader) checkRunningInstances(latestImageId string) (bool, error) {
+// are older than the latest, or if any instance doesn't have one of the given instance types
+func (u *Upgrader) checkRunningInstances(latestImageId string, instanceTypes []string) (bool, error) {
 	instanceList, err := u.getInstanceListForCluster(u.cluster)
 	if err != nil {
 		return false, fmt.Errorf("error retrieving instance list for cluster %s: %w", u.cluster, err)
@@ -1112,8 +1739,13 @@ func (u *Upgrader) checkRunningInstances(latestImageId string) (bool, error) {
 
 		for _, res := range instanceDetails.Reservations {
 			for _, inst := range res.Instances {
-				if *inst.ImageId != latestImageId {
-					u.logger.Printf("Found an older image (%s) running in the cluster.", *inst.ImageId)
+				// instances tagged for termination, such as those skipped for standalone tasks, are replaced
+				// with the orphaned instances
+				if isTaggedForTermination(inst) {
+					continue
+				}
+				if reason := outdatedInstanceReason(inst, latestImageId, instanceTypes); reason != "" {
+					u.logger.Printf("Found an outdated instance %s running in the cluster: %s", *inst.InstanceId, reason)
 					return true, nil
 				}
 			}
@@ -1,11 +1,15 @@
 package ead
 
 import (
+	"log"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
 )
 
 func Test_isNewerImage(t *testing.T) {
@@ -147,3 +151,144 @@ func TestSortLT(t *testing.T) {
 		}
 	}
 }
+
+func TestReverseSortImages(t *testing.T) {
+	images := []ec2types.Image{
+		{ImageId: aws.String("ami-old"), CreationDate: aws.String("2023-01-01T00:00:00.000Z")},
+		{ImageId: aws.String("ami-new"), CreationDate: aws.String("2023-03-01T00:00:00.000Z")},
+		{ImageId: aws.String("ami-mid"), CreationDate: aws.String("2023-02-01T00:00:00.000Z")},
+	}
+
+	if err := reverseSortImages(images); err != nil {
+		t.Fatalf("reverseSortImages() error = %v", err)
+	}
+
+	want := []string{"ami-new", "ami-mid", "ami-old"}
+	for i, img := range images {
+		if *img.ImageId != want[i] {
+			t.Errorf("index %d is %s, want %s", i, *img.ImageId, want[i])
+		}
+	}
+
+	images = append(images, ec2types.Image{ImageId: aws.String("ami-bad"), CreationDate: aws.String("not a date")})
+	if err := reverseSortImages(images); err == nil {
+		t.Error("expected error for unparseable creation date")
+	}
+}
+
+func TestAMIUsage(t *testing.T) {
+	images := []ec2types.Image{
+		{ImageId: aws.String("ami-new")},
+		{ImageId: aws.String("ami-old")},
+	}
+	clusters := []ClusterMeta{
+		{Cluster: ecsTypes.Cluster{ClusterName: aws.String("prod")}, Image: ec2types.Image{ImageId: aws.String("ami-old")}},
+		{Cluster: ecsTypes.Cluster{ClusterName: aws.String("staging")}, Image: ec2types.Image{ImageId: aws.String("ami-new")}},
+		{Cluster: ecsTypes.Cluster{ClusterName: aws.String("dev")}, Image: ec2types.Image{ImageId: aws.String("ami-old")}},
+	}
+
+	usage := AMIUsage(images, clusters)
+	if len(usage) != 2 {
+		t.Fatalf("got %d entries, want 2", len(usage))
+	}
+	if strings.Join(usage[0].Clusters, ",") != "staging" {
+		t.Errorf("ami-new used by %v, want [staging]", usage[0].Clusters)
+	}
+	if strings.Join(usage[1].Clusters, ",") != "prod,dev" {
+		t.Errorf("ami-old used by %v, want [prod dev]", usage[1].Clusters)
+	}
+}
+
+func TestVersionReference(t *testing.T) {
+	tests := []struct {
+		current *string
+		want    string
+	}{
+		{current: aws.String("$Latest"), want: "$Latest"},
+		{current: aws.String("$Default"), want: "$Default"},
+		{current: nil, want: "$Default"},
+		{current: aws.String("4"), want: "9"},
+	}
+	for _, tt := range tests {
+		if got := versionReference(tt.current, 9); got != tt.want {
+			t.Errorf("versionReference(%q) = %q, want %q", aws.ToString(tt.current), got, tt.want)
+		}
+	}
+}
+
+func TestTemplatesReferencingDefault(t *testing.T) {
+	asg := &asgTypes.AutoScalingGroup{
+		MixedInstancesPolicy: &asgTypes.MixedInstancesPolicy{
+			LaunchTemplate: &asgTypes.LaunchTemplate{
+				LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+					LaunchTemplateId: aws.String("lt-main"),
+					Version:          aws.String("$Latest"),
+				},
+				Overrides: []asgTypes.LaunchTemplateOverrides{
+					{LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-arm")}},
+					{LaunchTemplateSpecification: &asgTypes.LaunchTemplateSpecification{
+						LaunchTemplateId: aws.String("lt-pinned"),
+						Version:          aws.String("3"),
+					}},
+				},
+			},
+		},
+	}
+
+	got := templatesReferencingDefault(asg)
+	if len(got) != 1 || !got["lt-arm"] {
+		t.Errorf("got %v, want only lt-arm", got)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	u := &Upgrader{}
+	err := u.loadConfig(&Config{
+		Cluster:          "prod",
+		Logger:           log.Default(),
+		PollingInterval:  2 * time.Second,
+		PollingTimeout:   3 * time.Minute,
+		StabilityTimeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string]time.Duration{
+		"deployment": u.deploymentInterval, "registration": u.registrationInterval,
+		"stability": u.stabilityInterval, "warm pool": u.warmPoolInterval,
+	} {
+		if got != 2*time.Second {
+			t.Errorf("%s interval = %s, want the polling interval", name, got)
+		}
+	}
+	for name, got := range map[string]time.Duration{
+		"deployment": u.deploymentTimeout, "in-service": u.inServiceTimeout, "lifecycle hook": u.lifecycleHookTimeout,
+		"registration": u.registrationTimeout, "resource fit": u.resourceFitTimeout,
+		"standalone task": u.standaloneTaskTimeout, "warm pool": u.warmPoolTimeout,
+	} {
+		if got != 3*time.Minute {
+			t.Errorf("%s timeout = %s, want the polling timeout", name, got)
+		}
+	}
+	if u.stabilityTimeout != time.Minute {
+		t.Errorf("stability timeout = %s, want the configured 1m0s", u.stabilityTimeout)
+	}
+	if u.inServiceInterval != 0 {
+		t.Errorf("in-service interval = %s, want zero to keep the waiter's backoff", u.inServiceInterval)
+	}
+	if u.minimumIntervalsForStable != MinimumIntervalsForStable {
+		t.Errorf("minimum intervals for stable = %d, want %d", u.minimumIntervalsForStable, MinimumIntervalsForStable)
+	}
+
+	for _, config := range []Config{
+		{Cluster: "prod", Logger: log.Default(), RegistrationTimeout: -time.Minute},
+		{Cluster: "prod", Logger: log.Default(), PollingInterval: -time.Second},
+		{Cluster: "prod", Logger: log.Default(), MinimumIntervalsForStable: -1},
+		{Cluster: "prod", Logger: log.Default(), LaunchTemplateLimit: -1},
+		{Cluster: "prod", Logger: log.Default(), TaskFailureThreshold: -1},
+	} {
+		if err := (&Upgrader{}).loadConfig(&config); err == nil {
+			t.Errorf("loadConfig(%+v) should return an error", config)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package ead
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+)
+
+// instanceRequirements are what a new container instance must have before old instances are retired
+type instanceRequirements struct {
+	// imageID is the image the instance must run, if set
+	imageID             string
+	minimumAgentVersion string
+	requiredAttributes  []string
+	minimumCPU          int32
+	minimumMemory       int32
+}
+
+// verifyNewInstances checks every container instance that isn't one of the original instances against the
+// requirements, until they all pass or the registration timeout is reached
+func (u *Upgrader) verifyNewInstances(originalInstanceIDs []string, imageID string) error {
+	requirements := instanceRequirements{
+		imageID:             imageID,
+		minimumAgentVersion: u.minimumAgentVersion,
+		requiredAttributes:  u.requiredAttributes,
+		minimumCPU:          u.minimumRegisteredCPU,
+		minimumMemory:       u.minimumRegisteredMemory,
+	}
+
+	u.logger.Println("Verifying new container instances...")
+	startTime := time.Now()
+	for {
+		problems, err := u.checkNewInstances(originalInstanceIDs, requirements)
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			u.logger.Println("New container instances verified")
+			return nil
+		}
+
+		if time.Since(startTime) >= u.registrationTimeout {
+			return fmt.Errorf("new container instances failed verification: %s", strings.Join(problems, "; "))
+		}
+		u.logger.Printf("New container instances not verified yet: %s", strings.Join(problems, "; "))
+		if err := u.pause(u.registrationInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// checkNewInstances returns the problems found with the container instances that aren't original instances
+func (u *Upgrader) checkNewInstances(originalInstanceIDs []string, requirements instanceRequirements) ([]string, error) {
+	containerInstances, err := u.getInstanceListForCluster(u.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, ci := range containerInstances {
+		if !internal.IsStringInSlice(aws.ToString(ci.Ec2InstanceId), originalInstanceIDs) {
+			ids = append(ids, aws.ToString(ci.Ec2InstanceId))
+		}
+	}
+
+	images, err := u.getInstanceImages(ids)
+	if err != nil {
+		return nil, err
+	}
+	return newInstanceProblems(containerInstances, originalInstanceIDs, images, requirements), nil
+}
+
+// newInstanceProblems verifies the container instances that aren't original instances. New instances that run
+// another image, such as those that came from the warm pool, are replaced after the last batch by
+// findStaleNewInstances, so their image isn't held against them.
+func newInstanceProblems(containerInstances []ecsTypes.ContainerInstance, originalInstanceIDs []string,
+	images map[string]string, requirements instanceRequirements) []string {
+
+	var stale []string
+	for _, ci := range staleNewInstances(containerInstances, originalInstanceIDs, images, requirements.imageID) {
+		stale = append(stale, aws.ToString(ci.Ec2InstanceId))
+	}
+
+	var problems []string
+	for _, ci := range containerInstances {
+		id := aws.ToString(ci.Ec2InstanceId)
+		if internal.IsStringInSlice(id, originalInstanceIDs) {
+			continue
+		}
+		r := requirements
+		if internal.IsStringInSlice(id, stale) {
+			r.imageID = ""
+		}
+		problems = append(problems, verifyContainerInstance(ci, images[id], r)...)
+	}
+	return problems
+}
+
+// verifyContainerInstance returns the ways in which the container instance, running the given image, doesn't
+// meet the requirements
+func verifyContainerInstance(ci ecsTypes.ContainerInstance, imageID string, requirements instanceRequirements) []string {
+	id := aws.ToString(ci.Ec2InstanceId)
+	var problems []string
+	add := func(format string, a ...any) {
+		problems = append(problems, id+": "+fmt.Sprintf(format, a...))
+	}
+
+	if aws.ToString(ci.Status) != "ACTIVE" {
+		add("status is %s", aws.ToString(ci.Status))
+	}
+	if !ci.AgentConnected {
+		add("ECS agent is not connected")
+	}
+	if requirements.imageID != "" && imageID != requirements.imageID {
+		add("runs image %s instead of %s", imageID, requirements.imageID)
+	}
+
+	if requirements.minimumAgentVersion != "" {
+		agentVersion := ""
+		if ci.VersionInfo != nil {
+			agentVersion = aws.ToString(ci.VersionInfo.AgentVersion)
+		}
+		if agentVersion == "" {
+			add("ECS agent version is unknown")
+		} else if compareVersions(agentVersion, requirements.minimumAgentVersion) < 0 {
+			add("ECS agent version %s is older than %s", agentVersion, requirements.minimumAgentVersion)
+		}
+	}
+
+	for _, required := range requirements.requiredAttributes {
+		name, value, hasValue := strings.Cut(required, "=")
+		found := false
+		for _, a := range ci.Attributes {
+			if aws.ToString(a.Name) == name && (!hasValue || aws.ToString(a.Value) == value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("missing attribute %s", required)
+		}
+	}
+
+	cpu, memory := registeredResource(ci, "CPU"), registeredResource(ci, "MEMORY")
+	if cpu <= 0 || cpu < requirements.minimumCPU {
+		add("registered CPU is %d", cpu)
+	}
+	if memory <= 0 || memory < requirements.minimumMemory {
+		add("registered memory is %d MiB", memory)
+	}
+
+	return problems
+}
+
+// registeredResource returns the integer value of one of the container instance's registered resources
+func registeredResource(ci ecsTypes.ContainerInstance, name string) int32 {
+	for _, r := range ci.RegisteredResources {
+		if aws.ToString(r.Name) == name {
+			return r.IntegerValue
+		}
+	}
+	return 0
+}
+
+// compareVersions compares dotted version numbers such as 1.82.1, returning -1, 0 or 1. A leading "v" and any
+// suffix after a dash are ignored, and missing parts count as zero.
+func compareVersions(a, b string) int {
+	parse := func(v string) []int {
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+
+	pa, pb := parse(a), parse(b)
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na = pa[i]
+		}
+		if i < len(pb) {
+			nb = pb[i]
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package ead
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestVerifyContainerInstance(t *testing.T) {
+	healthy := ecsTypes.ContainerInstance{
+		Ec2InstanceId:  aws.String("i-new"),
+		Status:         aws.String("ACTIVE"),
+		AgentConnected: true,
+		VersionInfo:    &ecsTypes.VersionInfo{AgentVersion: aws.String("1.82.1")},
+		Attributes: []ecsTypes.Attribute{
+			{Name: aws.String("ecs.capability.efs")},
+			{Name: aws.String("ecs.os-family"), Value: aws.String("LINUX")},
+		},
+		RegisteredResources: []ecsTypes.Resource{
+			{Name: aws.String("CPU"), IntegerValue: 2048},
+			{Name: aws.String("MEMORY"), IntegerValue: 7680},
+		},
+	}
+	requirements := instanceRequirements{
+		imageID:             "ami-new",
+		minimumAgentVersion: "1.80.0",
+		requiredAttributes:  []string{"ecs.capability.efs", "ecs.os-family=LINUX"},
+		minimumCPU:          2048,
+		minimumMemory:       7000,
+	}
+
+	if problems := verifyContainerInstance(healthy, "ami-new", requirements); len(problems) != 0 {
+		t.Errorf("healthy instance has problems: %v", problems)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(ci *ecsTypes.ContainerInstance)
+		imageID string
+		want    string
+	}{
+		{"disconnected", func(ci *ecsTypes.ContainerInstance) { ci.AgentConnected = false }, "ami-new", "agent is not connected"},
+		{"draining", func(ci *ecsTypes.ContainerInstance) { ci.Status = aws.String("DRAINING") }, "ami-new", "status is DRAINING"},
+		{"old image", func(ci *ecsTypes.ContainerInstance) {}, "ami-old", "runs image ami-old instead of ami-new"},
+		{"old agent", func(ci *ecsTypes.ContainerInstance) {
+			ci.VersionInfo = &ecsTypes.VersionInfo{AgentVersion: aws.String("1.79.2")}
+		}, "ami-new", "version 1.79.2 is older than 1.80.0"},
+		{"missing attribute", func(ci *ecsTypes.ContainerInstance) { ci.Attributes = ci.Attributes[1:] }, "ami-new",
+			"missing attribute ecs.capability.efs"},
+		{"wrong attribute value", func(ci *ecsTypes.ContainerInstance) {
+			ci.Attributes = []ecsTypes.Attribute{ci.Attributes[0], {Name: aws.String("ecs.os-family"), Value: aws.String("WINDOWS")}}
+		}, "ami-new", "missing attribute ecs.os-family=LINUX"},
+		{"low memory", func(ci *ecsTypes.ContainerInstance) {
+			ci.RegisteredResources = ci.RegisteredResources[:1]
+		}, "ami-new", "registered memory is 0 MiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := healthy
+			tt.modify(&ci)
+			problems := verifyContainerInstance(ci, tt.imageID, requirements)
+			if len(problems) != 1 || !strings.HasPrefix(problems[0], "i-new: ") || !strings.Contains(problems[0], tt.want) {
+				t.Errorf("got problems %v, want one containing %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInstanceProblems(t *testing.T) {
+	instance := func(id string, connected bool) ecsTypes.ContainerInstance {
+		return ecsTypes.ContainerInstance{
+			Ec2InstanceId:  aws.String(id),
+			Status:         aws.String("ACTIVE"),
+			AgentConnected: connected,
+			RegisteredResources: []ecsTypes.Resource{
+				{Name: aws.String("CPU"), IntegerValue: 2048},
+				{Name: aws.String("MEMORY"), IntegerValue: 7680},
+			},
+		}
+	}
+	clusterInstances := []ecsTypes.ContainerInstance{
+		instance("i-old", false),
+		instance("i-new", true),
+		instance("i-warm", true),
+		instance("i-broken", false),
+	}
+	images := map[string]string{"i-new": "ami-new", "i-warm": "ami-old", "i-broken": "ami-new"}
+	original := []string{"i-old"}
+
+	// the warm pool instance on the old image is left for the stale instance replacement
+	stale := staleNewInstances(clusterInstances, original, images, "ami-new")
+	if len(stale) != 1 || aws.ToString(stale[0].Ec2InstanceId) != "i-warm" {
+		t.Fatalf("got stale instances %v, want i-warm", stale)
+	}
+
+	problems := newInstanceProblems(clusterInstances, original, images, instanceRequirements{imageID: "ami-new"})
+	if len(problems) != 1 || !strings.HasPrefix(problems[0], "i-broken: ") {
+		t.Errorf("got problems %v, want only the disconnected agent on i-broken", problems)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.82.1", "1.82.1", 0},
+		{"1.82.1", "1.80.0", 1},
+		{"1.9.0", "1.10.0", -1},
+		{"v1.82", "1.82.0", 0},
+		{"1.82.1-dev", "1.82.2", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
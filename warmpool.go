@@ -0,0 +1,167 @@
+package ead
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/silinternational/ecs-ami-deploy/v3/internal"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// describeWarmPool returns the ASG's warm pool configuration and the IDs of the instances in it. The
+// configuration is nil if the ASG has no warm pool.
+func (u *Upgrader) describeWarmPool(asgName string) (*asgTypes.WarmPoolConfiguration, []string, error) {
+	var config *asgTypes.WarmPoolConfiguration
+	var instances []string
+
+	in := &autoscaling.DescribeWarmPoolInput{AutoScalingGroupName: aws.String(asgName)}
+	for {
+		out, err := u.asgClient.DescribeWarmPool(context.Background(), in)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to describe warm pool of ASG %s: %w", asgName, err)
+		}
+		config = out.WarmPoolConfiguration
+		for _, i := range out.Instances {
+			instances = append(instances, *i.InstanceId)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		in.NextToken = out.NextToken
+	}
+
+	return config, instances, nil
+}
+
+// refreshWarmPool replaces the instances in the ASG's warm pool, so that it is refilled from the new launch
+// template version. The warm pool is deleted, along with its instances, and then recreated with the same
+// configuration. ASGs without a warm pool are left alone.
+func (u *Upgrader) refreshWarmPool(asgName string) error {
+	config, instances, err := u.describeWarmPool(asgName)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	u.logger.Printf("Refreshing warm pool of ASG %s with %d instances", asgName, len(instances))
+	_, err = u.asgClient.DeleteWarmPool(context.Background(), &autoscaling.DeleteWarmPoolInput{
+		AutoScalingGroupName: aws.String(asgName),
+		ForceDelete:          aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete warm pool of ASG %s: %w", asgName, err)
+	}
+
+	if err := u.waitForWarmPoolDeleted(asgName); err != nil {
+		return err
+	}
+
+	_, err = u.asgClient.PutWarmPool(context.Background(), &autoscaling.PutWarmPoolInput{
+		AutoScalingGroupName:     aws.String(asgName),
+		InstanceReusePolicy:      config.InstanceReusePolicy,
+		MaxGroupPreparedCapacity: config.MaxGroupPreparedCapacity,
+		MinSize:                  config.MinSize,
+		PoolState:                config.PoolState,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to recreate warm pool of ASG %s: %w", asgName, err)
+	}
+
+	u.logger.Printf("Warm pool of ASG %s recreated", asgName)
+	return nil
+}
+
+func (u *Upgrader) waitForWarmPoolDeleted(asgName string) error {
+	startTime := time.Now()
+	for {
+		if time.Since(startTime) >= u.warmPoolTimeout {
+			return fmt.Errorf("timeout while waiting for warm pool of ASG %s to be deleted", asgName)
+		}
+		if err := u.pause(u.warmPoolInterval); err != nil {
+			return err
+		}
+
+		config, instances, err := u.describeWarmPool(asgName)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			return nil
+		}
+		u.logger.Printf("Still waiting for warm pool of ASG %s to be deleted, %d instances remaining", asgName, len(instances))
+	}
+}
+
+// getInstanceImages returns the image ID of each of the given EC2 instances
+func (u *Upgrader) getInstanceImages(instanceIDs []string) (map[string]string, error) {
+	images := map[string]string{}
+	if len(instanceIDs) == 0 {
+		return images, nil
+	}
+
+	paginator := ec2.NewDescribeInstancesPaginator(u.ec2Client, &ec2.DescribeInstancesInput{InstanceIds: instanceIDs})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe instances: %w", err)
+		}
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				images[*i.InstanceId] = aws.ToString(i.ImageId)
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// findStaleNewInstances returns the container instances that are not among the original instances but are
+// running an image other than the given one
+func (u *Upgrader) findStaleNewInstances(originalInstanceIDs []string, imageID string) ([]ecsTypes.ContainerInstance, error) {
+	clusterInstances, err := u.getInstanceListForCluster(u.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	var newIDs []string
+	for _, c := range clusterInstances {
+		if !internal.IsStringInSlice(*c.Ec2InstanceId, originalInstanceIDs) {
+			newIDs = append(newIDs, *c.Ec2InstanceId)
+		}
+	}
+
+	images, err := u.getInstanceImages(newIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := staleNewInstances(clusterInstances, originalInstanceIDs, images, imageID)
+	for _, c := range stale {
+		u.logger.Printf("New instance %s is running image %s instead of %s and will be replaced",
+			*c.Ec2InstanceId, images[*c.Ec2InstanceId], imageID)
+	}
+	return stale, nil
+}
+
+// staleNewInstances returns the container instances that are not among the original instances but, according
+// to images, run an image other than imageID
+func staleNewInstances(clusterInstances []ecsTypes.ContainerInstance, originalInstanceIDs []string,
+	images map[string]string, imageID string) []ecsTypes.ContainerInstance {
+
+	var stale []ecsTypes.ContainerInstance
+	for _, c := range clusterInstances {
+		id := aws.ToString(c.Ec2InstanceId)
+		if !internal.IsStringInSlice(id, originalInstanceIDs) && images[id] != imageID {
+			stale = append(stale, c)
+		}
+	}
+	return stale
+}
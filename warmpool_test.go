@@ -0,0 +1,31 @@
+package ead
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestStaleNewInstances(t *testing.T) {
+	instance := func(id string) ecsTypes.ContainerInstance {
+		return ecsTypes.ContainerInstance{Ec2InstanceId: aws.String(id)}
+	}
+	clusterInstances := []ecsTypes.ContainerInstance{
+		instance("i-old"), instance("i-new"), instance("i-warm"), instance("i-unknown"),
+	}
+	images := map[string]string{
+		"i-old":  "ami-old",
+		"i-new":  "ami-new",
+		"i-warm": "ami-old",
+	}
+
+	var got []string
+	for _, c := range staleNewInstances(clusterInstances, []string{"i-old"}, images, "ami-new") {
+		got = append(got, *c.Ec2InstanceId)
+	}
+	if want := "[i-warm i-unknown]"; fmt.Sprint(got) != want {
+		t.Errorf("got stale instances %v, want %s", got, want)
+	}
+}